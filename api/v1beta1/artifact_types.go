@@ -47,6 +47,125 @@ type Artifact struct {
 	// artifact.
 	// +required
 	LastUpdateTime metav1.Time `json:"lastUpdateTime,omitempty"`
+
+	// ContentType is the media type of the artifact file.
+	// +optional
+	ContentType string `json:"contentType,omitempty"`
+
+	// Signature is the reference to the detached signature of the artifact,
+	// set when the controller is configured with a signing key.
+	// +optional
+	Signature *ArtifactSignature `json:"signature,omitempty"`
+
+	// Manifest is the reference to the manifest of the files in the artifact,
+	// set for artifacts archived from a directory.
+	// +optional
+	Manifest *ArtifactManifest `json:"manifest,omitempty"`
+
+	// Metadata is the reference to the metadata document of the artifact,
+	// describing where it originates from.
+	// +optional
+	Metadata *ArtifactMetadata `json:"metadata,omitempty"`
+}
+
+// ArtifactSignature is the reference to the detached signature of an Artifact.
+type ArtifactSignature struct {
+	// Path is the relative file path of the signature.
+	// +required
+	Path string `json:"path"`
+
+	// URL is the HTTP address of the signature.
+	// +required
+	URL string `json:"url"`
+
+	// KeyID is the hex encoded SHA256 hash of the DER encoded public key the
+	// signature can be verified with.
+	// +optional
+	KeyID string `json:"keyID,omitempty"`
+}
+
+// ArtifactManifest is the reference to the manifest of the files in an
+// Artifact.
+type ArtifactManifest struct {
+	// Path is the relative file path of the manifest.
+	// +required
+	Path string `json:"path"`
+
+	// URL is the HTTP address of the manifest.
+	// +required
+	URL string `json:"url"`
+
+	// Checksum is the SHA256 checksum of the manifest.
+	// +optional
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// ArtifactMetadata is the reference to the metadata document of an Artifact.
+type ArtifactMetadata struct {
+	// Path is the relative file path of the metadata document.
+	// +required
+	Path string `json:"path"`
+
+	// URL is the HTTP address of the metadata document.
+	// +required
+	URL string `json:"url"`
+}
+
+const (
+	// ArtifactFormatGzip is the format of gzip compressed tarball artifacts.
+	ArtifactFormatGzip string = "gzip"
+	// ArtifactFormatZstd is the format of zstd compressed tarball artifacts.
+	ArtifactFormatZstd string = "zstd"
+	// ArtifactFormatOCI is the format of tarball artifacts containing an OCI
+	// image layout, with the gzip compressed source tarball as single layer.
+	ArtifactFormatOCI string = "oci"
+)
+
+const (
+	// ArtifactContentTypeGzip is the content type of ArtifactFormatGzip
+	// artifacts.
+	ArtifactContentTypeGzip string = "application/tar+gzip"
+	// ArtifactContentTypeZstd is the content type of ArtifactFormatZstd
+	// artifacts.
+	ArtifactContentTypeZstd string = "application/tar+zstd"
+	// ArtifactContentTypeOCI is the content type of ArtifactFormatOCI
+	// artifacts.
+	ArtifactContentTypeOCI string = "application/vnd.oci.image.layout.v1+tar"
+)
+
+// ArtifactRetention defines how many previous artifacts of a source are kept
+// in storage after they have been superseded by a new artifact.
+type ArtifactRetention struct {
+	// Records is the number of previous artifacts to retain next to the
+	// current artifact.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Records int `json:"records,omitempty"`
+
+	// MinAge is the minimum duration a previous artifact is retained for after
+	// it has been superseded, regardless of the number of Records.
+	// +optional
+	MinAge *metav1.Duration `json:"minAge,omitempty"`
+}
+
+// ArtifactLimits defines the limits of the files in an artifact produced from a
+// source. A zero value is unlimited.
+type ArtifactLimits struct {
+	// MaxSize is the maximum total size in bytes of the files in the artifact.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxSize int64 `json:"maxSize,omitempty"`
+
+	// MaxFiles is the maximum number of files in the artifact.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxFiles int64 `json:"maxFiles,omitempty"`
+
+	// MaxFileSize is the maximum size in bytes of a single file in the
+	// artifact.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxFileSize int64 `json:"maxFileSize,omitempty"`
 }
 
 // HasRevision returns true if the given revision matches the current Revision
@@ -32,7 +32,7 @@ const (
 // BucketSpec defines the desired state of an S3 compatible bucket
 type BucketSpec struct {
 	// The S3 compatible storage provider name, default ('generic').
-	// +kubebuilder:validation:Enum=generic;aws;gcp
+	// +kubebuilder:validation:Enum=generic;aws;gcp;azure
 	// +kubebuilder:default:=generic
 	// +optional
 	Provider string `json:"provider,omitempty"`
@@ -41,11 +41,26 @@ type BucketSpec struct {
 	// +required
 	BucketName string `json:"bucketName"`
 
-	// The bucket endpoint address.
+	// Prefix is the key prefix of the objects to include in the artifact,
+	// e.g. 'deploy/production'. It is handled as a directory, so the objects
+	// of which the key starts with the prefix followed by a '/' are included,
+	// with the prefix removed from their path in the artifact.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// PointInTime pins the Bucket to the objects as they were at the given
+	// time, by including the latest version of every object written at or
+	// before it. It requires object versioning to be enabled on the bucket,
+	// and is supported by the 'generic', 'aws' and 'gcp' providers.
+	// +optional
+	PointInTime *metav1.Time `json:"pointInTime,omitempty"`
+
+	// The bucket endpoint address. For the 'azure' provider, this is the
+	// address of the storage account, e.g. '<account>.blob.core.windows.net'.
 	// +required
 	Endpoint string `json:"endpoint"`
 
-	// Insecure allows connecting to a non-TLS S3 HTTP endpoint.
+	// Insecure allows connecting to a non-TLS HTTP Endpoint.
 	// +optional
 	Insecure bool `json:"insecure,omitempty"`
 
@@ -58,6 +73,11 @@ type BucketSpec struct {
 	// +optional
 	SecretRef *meta.LocalObjectReference `json:"secretRef,omitempty"`
 
+	// STS configures the 'generic' and 'aws' providers to authenticate with
+	// temporary credentials of a role assumed with a Security Token Service.
+	// +optional
+	STS *BucketSTSSpec `json:"sts,omitempty"`
+
 	// The interval at which to check for bucket updates.
 	// +required
 	Interval metav1.Duration `json:"interval"`
@@ -80,12 +100,58 @@ type BucketSpec struct {
 	// AccessFrom defines an Access Control List for allowing cross-namespace references to this object.
 	// +optional
 	AccessFrom *acl.AccessFrom `json:"accessFrom,omitempty"`
+
+	// ArtifactRetention defines how many previous artifacts are kept in storage,
+	// defaults to the retention policy configured for the controller.
+	// +optional
+	ArtifactRetention *ArtifactRetention `json:"artifactRetention,omitempty"`
+
+	// ArtifactFormat is the format of the artifact produced for this source,
+	// defaults to 'gzip'.
+	// +kubebuilder:validation:Enum=gzip;zstd;oci
+	// +kubebuilder:default:=gzip
+	// +optional
+	ArtifactFormat string `json:"artifactFormat,omitempty"`
+
+	// ArtifactLimits defines the limits of the files in the artifact, which
+	// can only lower the limits configured for the controller.
+	// +optional
+	ArtifactLimits *ArtifactLimits `json:"artifactLimits,omitempty"`
+}
+
+// BucketSTSSpec specifies the Security Token Service to assume a role with,
+// like AWS STS or the STS of MinIO.
+type BucketSTSSpec struct {
+	// Endpoint is the HTTP/S URL of the Security Token Service, e.g.
+	// 'https://sts.amazonaws.com'.
+	// +kubebuilder:validation:Pattern="^(http|https)://.*$"
+	// +required
+	Endpoint string `json:"endpoint"`
+
+	// RoleARN is the Amazon Resource Name of the role to assume. It is
+	// required by AWS STS.
+	// +optional
+	RoleARN string `json:"roleARN,omitempty"`
+
+	// RoleSessionName is the identifier of the assumed role session,
+	// defaults to '<namespace>.<name>' of the Bucket.
+	// +optional
+	RoleSessionName string `json:"roleSessionName,omitempty"`
+
+	// WebIdentity assumes the role with AssumeRoleWithWebIdentity, using the
+	// web identity token file configured for the controller, which requires
+	// the role to be allowed for the namespace of the Bucket. If false, the
+	// role is assumed with AssumeRole, using the 'accesskey' and 'secretkey'
+	// of the secret.
+	// +optional
+	WebIdentity bool `json:"webIdentity,omitempty"`
 }
 
 const (
 	GenericBucketProvider string = "generic"
 	AmazonBucketProvider  string = "aws"
 	GoogleBucketProvider  string = "gcp"
+	AzureBucketProvider   string = "azure"
 )
 
 // BucketStatus defines the observed state of a bucket
@@ -106,6 +172,11 @@ type BucketStatus struct {
 	// +optional
 	Artifact *Artifact `json:"artifact,omitempty"`
 
+	// RetainedArtifacts holds the previous artifacts that are kept in storage
+	// according to the artifact retention policy, newest first.
+	// +optional
+	RetainedArtifacts []Artifact `json:"retainedArtifacts,omitempty"`
+
 	meta.ReconcileRequestStatus `json:",inline"`
 }
 
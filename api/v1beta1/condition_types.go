@@ -25,6 +25,10 @@ const (
 	// StorageOperationFailedReason signals a failure caused by a storage operation.
 	StorageOperationFailedReason string = "StorageOperationFailed"
 
+	// ArtifactLimitExceededReason signals that the files of a source exceed
+	// the configured artifact limits.
+	ArtifactLimitExceededReason string = "ArtifactLimitExceeded"
+
 	// AuthenticationFailedReason represents the fact that a given secret does not
 	// have the required fields or the provided credentials do not match.
 	AuthenticationFailedReason string = "AuthenticationFailed"
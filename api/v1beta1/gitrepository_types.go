@@ -96,6 +96,23 @@ type GitRepositorySpec struct {
 	// AccessFrom defines an Access Control List for allowing cross-namespace references to this object.
 	// +optional
 	AccessFrom *acl.AccessFrom `json:"accessFrom,omitempty"`
+
+	// ArtifactRetention defines how many previous artifacts are kept in storage,
+	// defaults to the retention policy configured for the controller.
+	// +optional
+	ArtifactRetention *ArtifactRetention `json:"artifactRetention,omitempty"`
+
+	// ArtifactFormat is the format of the artifact produced for this source,
+	// defaults to 'gzip'.
+	// +kubebuilder:validation:Enum=gzip;zstd;oci
+	// +kubebuilder:default:=gzip
+	// +optional
+	ArtifactFormat string `json:"artifactFormat,omitempty"`
+
+	// ArtifactLimits defines the limits of the files in the artifact, which
+	// can only lower the limits configured for the controller.
+	// +optional
+	ArtifactLimits *ArtifactLimits `json:"artifactLimits,omitempty"`
 }
 
 func (in *GitRepositoryInclude) GetFromPath() string {
@@ -175,6 +192,11 @@ type GitRepositoryStatus struct {
 	// +optional
 	IncludedArtifacts []*Artifact `json:"includedArtifacts,omitempty"`
 
+	// RetainedArtifacts holds the previous artifacts that are kept in storage
+	// according to the artifact retention policy, newest first.
+	// +optional
+	RetainedArtifacts []Artifact `json:"retainedArtifacts,omitempty"`
+
 	meta.ReconcileRequestStatus `json:",inline"`
 }
 
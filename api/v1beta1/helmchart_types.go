@@ -78,6 +78,11 @@ type HelmChartSpec struct {
 	// AccessFrom defines an Access Control List for allowing cross-namespace references to this object.
 	// +optional
 	AccessFrom *acl.AccessFrom `json:"accessFrom,omitempty"`
+
+	// ArtifactRetention defines how many previous artifacts are kept in storage,
+	// defaults to the retention policy configured for the controller.
+	// +optional
+	ArtifactRetention *ArtifactRetention `json:"artifactRetention,omitempty"`
 }
 
 const (
@@ -124,6 +129,11 @@ type HelmChartStatus struct {
 	// +optional
 	Artifact *Artifact `json:"artifact,omitempty"`
 
+	// RetainedArtifacts holds the previous artifacts that are kept in storage
+	// according to the artifact retention policy, newest first.
+	// +optional
+	RetainedArtifacts []Artifact `json:"retainedArtifacts,omitempty"`
+
 	meta.ReconcileRequestStatus `json:",inline"`
 }
 
@@ -72,6 +72,11 @@ type HelmRepositorySpec struct {
 	// AccessFrom defines an Access Control List for allowing cross-namespace references to this object.
 	// +optional
 	AccessFrom *acl.AccessFrom `json:"accessFrom,omitempty"`
+
+	// ArtifactRetention defines how many previous artifacts are kept in storage,
+	// defaults to the retention policy configured for the controller.
+	// +optional
+	ArtifactRetention *ArtifactRetention `json:"artifactRetention,omitempty"`
 }
 
 // HelmRepositoryStatus defines the observed state of the HelmRepository.
@@ -92,6 +97,11 @@ type HelmRepositoryStatus struct {
 	// +optional
 	Artifact *Artifact `json:"artifact,omitempty"`
 
+	// RetainedArtifacts holds the previous artifacts that are kept in storage
+	// according to the artifact retention policy, newest first.
+	// +optional
+	RetainedArtifacts []Artifact `json:"retainedArtifacts,omitempty"`
+
 	meta.ReconcileRequestStatus `json:",inline"`
 }
 
@@ -0,0 +1,273 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+
+	"github.com/fluxcd/source-controller/internal/fs"
+	"github.com/fluxcd/source-controller/pkg/objectstore"
+)
+
+const (
+	// bucketCacheIndexFile is the name of the file in a bucket cache directory holding the bucketCacheIndex.
+	bucketCacheIndexFile = "index.json"
+	// bucketCacheObjectsDir is the name of the directory in a bucket cache directory holding the objects.
+	bucketCacheObjectsDir = "objects"
+)
+
+// bucketCache is a directory with the objects of a Bucket, and an index of their ETags, which is kept between
+// reconciliations so that only the objects that changed in the bucket are downloaded again. Objects can be put
+// into the cache concurrently.
+type bucketCache struct {
+	// dir is the directory of the cache.
+	dir string
+	// index is the index of the objects in the cache.
+	index bucketCacheIndex
+	// mu guards the objects in the index.
+	mu sync.Mutex
+}
+
+// bucketCacheIndex is the index of the objects in a bucketCache.
+type bucketCacheIndex struct {
+	// Origin identifies the bucket the objects were downloaded from.
+	Origin string `json:"origin"`
+	// Objects are the cached objects by key.
+	Objects map[string]bucketCacheEntry `json:"objects"`
+}
+
+// bucketCacheEntry is an object in a bucketCache.
+type bucketCacheEntry struct {
+	// ETag is the ETag of the object when it was downloaded.
+	ETag string `json:"etag"`
+	// VersionID is the ID of the version of the object that was downloaded, if any.
+	VersionID string `json:"versionID,omitempty"`
+	// Checksum is the hex encoded SHA1 checksum of the object.
+	Checksum string `json:"checksum"`
+}
+
+// openBucketCache opens the bucketCache in the given directory for the bucket identified by the given origin,
+// creating it if it does not exist. A cache with an unreadable index, or with objects of another origin, is emptied.
+func openBucketCache(dir, origin string) (*bucketCache, error) {
+	c := &bucketCache{dir: dir}
+	b, err := os.ReadFile(filepath.Join(dir, bucketCacheIndexFile))
+	if err == nil {
+		err = json.Unmarshal(b, &c.index)
+	}
+	if err != nil || c.index.Origin != origin || c.index.Objects == nil {
+		c.index = bucketCacheIndex{Origin: origin, Objects: map[string]bucketCacheEntry{}}
+		if err := os.RemoveAll(c.objectsDir()); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(c.objectsDir(), 0700); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// objectsDir returns the directory holding the cached objects.
+func (c *bucketCache) objectsDir() string {
+	return filepath.Join(c.dir, bucketCacheObjectsDir)
+}
+
+// objectPath returns the path of the object with the given key in the objects directory.
+func (c *bucketCache) objectPath(key string) (string, error) {
+	return securejoin.SecureJoin(c.objectsDir(), key)
+}
+
+// fresh returns true if the given object is cached with its non-empty ETag and its version ID.
+func (c *bucketCache) fresh(object objectstore.ObjectInfo) bool {
+	c.mu.Lock()
+	e, ok := c.index.Objects[object.Key]
+	c.mu.Unlock()
+	if !ok || object.ETag == "" || e.ETag != object.ETag || e.VersionID != object.VersionID {
+		return false
+	}
+	p, err := c.objectPath(object.Key)
+	if err != nil {
+		return false
+	}
+	fi, err := os.Stat(p)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// put caches the given object, which is written by the given download function to the path passed to it. The object
+// is only moved into the objects directory after it has been downloaded completely.
+func (c *bucketCache) put(object objectstore.ObjectInfo, download func(path string) error) error {
+	p, err := c.objectPath(object.Key)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp(c.dir, "download-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+	tmp := filepath.Join(tmpDir, "object")
+	if err := download(tmp); err != nil {
+		return err
+	}
+	checksum, err := fileSHA1(tmp)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return err
+	}
+	if err := fs.RenameWithFallback(tmp, p); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.index.Objects[object.Key] = bucketCacheEntry{ETag: object.ETag, VersionID: object.VersionID, Checksum: checksum}
+	c.mu.Unlock()
+	return nil
+}
+
+// prune removes the objects of which the key is not in the given set from the cache, including any files in the
+// objects directory that are not in the index.
+func (c *bucketCache) prune(keep map[string]struct{}) error {
+	for key := range c.index.Objects {
+		if _, ok := keep[key]; !ok {
+			delete(c.index.Objects, key)
+		}
+	}
+	keepPaths := make(map[string]struct{}, len(c.index.Objects))
+	for key := range c.index.Objects {
+		p, err := c.objectPath(key)
+		if err != nil {
+			return err
+		}
+		keepPaths[p] = struct{}{}
+	}
+
+	root := c.objectsDir()
+	var dirs []string
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			if p != root {
+				dirs = append(dirs, p)
+			}
+			return nil
+		}
+		if _, ok := keepPaths[p]; !ok {
+			return os.Remove(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// Remove the directories left empty, deepest first.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if entries, err := os.ReadDir(dirs[i]); err == nil && len(entries) == 0 {
+			os.Remove(dirs[i])
+		}
+	}
+	return nil
+}
+
+// save atomically writes the index of the cache.
+func (c *bucketCache) save() error {
+	c.mu.Lock()
+	b, err := json.Marshal(c.index)
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	tmp := filepath.Join(c.dir, bucketCacheIndexFile+".tmp")
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(c.dir, bucketCacheIndexFile))
+}
+
+// revision returns the SHA1 checksum of the list with the relative paths of the cached objects and their checksums,
+// in the order the objects directory is walked in. Without object versions, it equals the checksum of the objects
+// directory calculated by reading every object. The version IDs of the objects are appended to their paths.
+func (c *bucketCache) revision() (string, error) {
+	type object struct {
+		elems     []string
+		rel       string
+		checksum  string
+		versionID string
+	}
+	objects := make([]object, 0, len(c.index.Objects))
+	for key, e := range c.index.Objects {
+		p, err := c.objectPath(key)
+		if err != nil {
+			return "", err
+		}
+		rel, err := filepath.Rel(c.objectsDir(), p)
+		if err != nil {
+			return "", err
+		}
+		objects = append(objects, object{
+			elems:     strings.Split(rel, string(filepath.Separator)),
+			rel:       rel,
+			checksum:  e.Checksum,
+			versionID: e.VersionID,
+		})
+	}
+	// filepath.Walk visits the entries of a directory in lexical order, which sorts paths by their elements.
+	sort.Slice(objects, func(i, j int) bool {
+		a, b := objects[i].elems, objects[j].elems
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	sum := sha1.New()
+	for _, o := range objects {
+		if o.versionID != "" {
+			sum.Write([]byte(fmt.Sprintf("%s  %s  %s\n", o.checksum, o.rel, o.versionID)))
+			continue
+		}
+		sum.Write([]byte(fmt.Sprintf("%s  %s\n", o.checksum, o.rel)))
+	}
+	return fmt.Sprintf("%x", sum.Sum(nil)), nil
+}
+
+// fileSHA1 returns the hex encoded SHA1 checksum of the file at the given path.
+func fileSHA1(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
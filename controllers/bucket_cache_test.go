@@ -0,0 +1,206 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fluxcd/source-controller/pkg/objectstore"
+)
+
+func Test_bucketCache_revision(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects map[string]string
+		want    string
+	}{
+		{
+			name: "empty cache",
+			want: "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+		},
+		{
+			name:    "with object",
+			objects: map[string]string{"a/b/c.txt": "a dummy string"},
+			want:    "309a5e6e96b4a7eea0d1cfaabf1be8ec1c063fa0",
+		},
+		{
+			name:    "with object in different path",
+			objects: map[string]string{"a/b.txt": "a dummy string"},
+			want:    "e28c62b5cc488849950c4355dddc5523712616d4",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, err := openBucketCache(t.TempDir(), "origin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, content := range tt.objects {
+				if err := cache.put(objectstore.ObjectInfo{Key: key, ETag: "etag"}, func(path string) error {
+					return mockFile(filepath.Dir(path), filepath.Base(path), content)
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := cache.revision()
+			if err != nil {
+				t.Fatalf("revision() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("revision() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_bucketCache_revisionOrder(t *testing.T) {
+	// The revision must equal the checksum calculated by walking the
+	// directory, in which 'a/b' is visited before 'a-b' and 'a.b'.
+	cache, err := openBucketCache(t.TempDir(), "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a.b", "a-b", "a/b"} {
+		if err := cache.put(objectstore.ObjectInfo{Key: key, ETag: "etag"}, func(path string) error {
+			return mockFile(filepath.Dir(path), filepath.Base(path), key)
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := cache.revision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "d62a2d4338fdfac79dd4099a387c92ab594ca7a7"; got != want {
+		t.Errorf("revision() got = %v, want %v", got, want)
+	}
+}
+
+func Test_bucketCache_revisionVersions(t *testing.T) {
+	// The version IDs of the objects are part of the revision, while the
+	// revision of objects without version equals the checksum of their
+	// content.
+	revision := func(versionID string) string {
+		cache, err := openBucketCache(t.TempDir(), "origin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		object := objectstore.ObjectInfo{Key: "a/b.txt", ETag: "etag", VersionID: versionID}
+		if err := cache.put(object, func(path string) error {
+			return mockFile(filepath.Dir(path), filepath.Base(path), "a dummy string")
+		}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := cache.revision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	if got := revision(""); got != "e28c62b5cc488849950c4355dddc5523712616d4" {
+		t.Errorf("revision() without version = %v", got)
+	}
+	v1, v2 := revision("1"), revision("2")
+	if v1 == v2 || v1 == "e28c62b5cc488849950c4355dddc5523712616d4" {
+		t.Errorf("revision() does not depend on version ID: %v, %v", v1, v2)
+	}
+}
+
+func Test_bucketCache(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := openBucketCache(dir, "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a.yaml", "dir/b.yaml"} {
+		if cache.fresh(objectstore.ObjectInfo{Key: key, ETag: "1"}) {
+			t.Errorf("fresh(%s) = true before put", key)
+		}
+		if err := cache.put(objectstore.ObjectInfo{Key: key, ETag: "1"}, func(path string) error {
+			return mockFile(filepath.Dir(path), filepath.Base(path), key)
+		}); err != nil {
+			t.Fatalf("put(%s) error = %v", key, err)
+		}
+		if !cache.fresh(objectstore.ObjectInfo{Key: key, ETag: "1"}) {
+			t.Errorf("fresh(%s) = false after put", key)
+		}
+		if cache.fresh(objectstore.ObjectInfo{Key: key, ETag: "2"}) || cache.fresh(objectstore.ObjectInfo{Key: key, ETag: ""}) {
+			t.Errorf("fresh(%s) = true for other ETag", key)
+		}
+		if cache.fresh(objectstore.ObjectInfo{Key: key, ETag: "1", VersionID: "1"}) {
+			t.Errorf("fresh(%s) = true for other version", key)
+		}
+	}
+	if err := cache.put(objectstore.ObjectInfo{Key: "../escape.yaml", ETag: "1"}, func(path string) error {
+		if !strings.HasPrefix(path, dir) {
+			t.Errorf("put() downloads to %s outside of cache", path)
+		}
+		return os.ErrNotExist
+	}); err == nil {
+		t.Error("put() expected download error")
+	}
+	if err := cache.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	// Reopening the cache keeps the objects of the same origin.
+	cache, err = openBucketCache(dir, "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cache.fresh(objectstore.ObjectInfo{Key: "a.yaml", ETag: "1"}) || !cache.fresh(objectstore.ObjectInfo{Key: "dir/b.yaml", ETag: "1"}) {
+		t.Errorf("openBucketCache() lost objects: %v", cache.index.Objects)
+	}
+
+	// Pruning removes the objects that are not kept, and their directories.
+	mockFile(cache.objectsDir(), "stray.yaml", "not in index")
+	if err := cache.prune(map[string]struct{}{"a.yaml": {}}); err != nil {
+		t.Fatalf("prune() error = %v", err)
+	}
+	if files := listFiles(cache.objectsDir()); strings.Join(files, ",") != "a.yaml" {
+		t.Errorf("prune() left %v", files)
+	}
+	if _, err := os.Stat(filepath.Join(cache.objectsDir(), "dir")); !os.IsNotExist(err) {
+		t.Errorf("prune() left empty directory: %v", err)
+	}
+	if err := cache.save(); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	// Opening the cache for another origin empties it.
+	cache, err = openBucketCache(dir, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cache.index.Objects) != 0 || len(listFiles(cache.objectsDir())) != 0 {
+		t.Errorf("openBucketCache() kept objects of other origin: %v", cache.index.Objects)
+	}
+
+	// A corrupt index empties the cache.
+	if err := os.WriteFile(filepath.Join(dir, bucketCacheIndexFile), []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if cache, err = openBucketCache(dir, "other"); err != nil {
+		t.Fatalf("openBucketCache() error = %v", err)
+	}
+	if len(cache.index.Objects) != 0 {
+		t.Errorf("openBucketCache() kept objects of corrupt index: %v", cache.index.Objects)
+	}
+}
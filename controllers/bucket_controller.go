@@ -18,22 +18,28 @@ package controllers
 
 import (
 	"context"
-	"crypto/sha1"
+	"crypto/tls"
 	"fmt"
+	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
-	"github.com/minio/minio-go/v7/pkg/s3utils"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/semaphore"
 	"google.golang.org/api/option"
 	corev1 "k8s.io/api/core/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
 	kuberecorder "k8s.io/client-go/tools/record"
 	"k8s.io/client-go/tools/reference"
 	ctrl "sigs.k8s.io/controller-runtime"
@@ -46,7 +52,9 @@ import (
 	"github.com/fluxcd/pkg/runtime/events"
 	"github.com/fluxcd/pkg/runtime/metrics"
 	"github.com/fluxcd/pkg/runtime/predicates"
+	"github.com/fluxcd/source-controller/pkg/azure"
 	"github.com/fluxcd/source-controller/pkg/gcp"
+	"github.com/fluxcd/source-controller/pkg/objectstore"
 
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 	"github.com/fluxcd/source-controller/pkg/sourceignore"
@@ -65,6 +73,37 @@ type BucketReconciler struct {
 	EventRecorder         kuberecorder.EventRecorder
 	ExternalEventRecorder *events.Recorder
 	MetricsRecorder       *metrics.Recorder
+
+	// CachePath is the directory in which the objects of every Bucket are
+	// cached between reconciliations, so that only changed objects are
+	// downloaded. Objects are downloaded into a temporary directory on
+	// every reconciliation if not set.
+	CachePath string
+
+	// DownloadConcurrency is the number of objects of a Bucket that are
+	// downloaded concurrently. Objects are downloaded one by one if not set.
+	DownloadConcurrency int
+
+	// STSWebIdentityTokenFile is the path to the web identity token file
+	// with which the roles of Buckets are assumed with
+	// AssumeRoleWithWebIdentity. Buckets can not use web identities if not
+	// set.
+	STSWebIdentityTokenFile string
+
+	// STSWebIdentityRoles are the ARNs of the roles the Buckets in a
+	// namespace are allowed to assume with the web identity token file, by
+	// namespace, or for any namespace by "*". Buckets can not use web
+	// identities for roles that are not listed.
+	STSWebIdentityRoles map[string][]string
+}
+
+// bucketDownloadBackoff is the backoff with which the download of an object
+// is retried on transient errors.
+var bucketDownloadBackoff = wait.Backoff{
+	Duration: 500 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    4,
 }
 
 type BucketReconcilerOptions struct {
@@ -142,7 +181,11 @@ func (r *BucketReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		bucket.Status.SetLastHandledReconcileRequest(v)
 	}
 
-	// purge old artifacts from storage
+	// apply the artifact retention policy and purge old artifacts from storage
+	if artifact := bucket.GetArtifact(); artifact != nil {
+		bucket.Status.RetainedArtifacts = r.Storage.RetainArtifacts(bucket.Spec.ArtifactRetention, *artifact, nil,
+			bucket.Status.RetainedArtifacts)
+	}
 	if err := r.gc(bucket); err != nil {
 		log.Error(err, "unable to purge old artifacts")
 	}
@@ -174,44 +217,52 @@ func (r *BucketReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		bucket.GetInterval().Duration.String(),
 	))
 
-	return ctrl.Result{RequeueAfter: bucket.GetInterval().Duration}, nil
+	return ctrl.Result{RequeueAfter: r.Storage.RequeueAfter(bucket.GetInterval().Duration)}, nil
 }
 
 func (r *BucketReconciler) reconcile(ctx context.Context, bucket sourcev1.Bucket) (sourcev1.Bucket, error) {
 	log := ctrl.LoggerFrom(ctx)
-	var err error
-	var sourceBucket sourcev1.Bucket
-
-	tempDir, err := os.MkdirTemp("", bucket.Name)
+	cacheDir := r.cacheDir(bucket)
+	if cacheDir == "" {
+		tempDir, err := os.MkdirTemp("", bucket.Name)
+		if err != nil {
+			err = fmt.Errorf("tmp dir error: %w", err)
+			return sourcev1.BucketNotReady(bucket, sourcev1.StorageOperationFailedReason, err.Error()), err
+		}
+		defer func() {
+			if err := os.RemoveAll(tempDir); err != nil {
+				log.Error(err, "failed to remove working directory", "path", tempDir)
+			}
+		}()
+		cacheDir = tempDir
+	}
+	cache, err := openBucketCache(cacheDir, bucket.Spec.Provider+"+"+bucketOrigin(bucket))
 	if err != nil {
-		err = fmt.Errorf("tmp dir error: %w", err)
+		err = fmt.Errorf("cache dir error: %w", err)
 		return sourcev1.BucketNotReady(bucket, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
-	defer func() {
-		if err := os.RemoveAll(tempDir); err != nil {
-			log.Error(err, "failed to remove working directory", "path", tempDir)
-		}
-	}()
 
-	if bucket.Spec.Provider == sourcev1.GoogleBucketProvider {
-		sourceBucket, err = r.reconcileWithGCP(ctx, bucket, tempDir)
-		if err != nil {
-			return sourceBucket, err
-		}
-	} else {
-		sourceBucket, err = r.reconcileWithMinio(ctx, bucket, tempDir)
-		if err != nil {
-			return sourceBucket, err
-		}
+	provider, err := r.newProvider(ctx, bucket)
+	if err != nil {
+		err = fmt.Errorf("auth error: %w", err)
+		return sourcev1.BucketNotReady(bucket, sourcev1.AuthenticationFailedReason, err.Error()), err
 	}
-	revision, err := r.checksum(tempDir)
+	defer provider.Close(ctx)
+	if sourceBucket, err := r.reconcileWithProvider(ctx, bucket, provider, cache); err != nil {
+		return sourceBucket, err
+	}
+	revision, err := cache.revision()
 	if err != nil {
 		return sourcev1.BucketNotReady(bucket, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
+	tempDir := cache.objectsDir()
 
-	// return early on unchanged revision
-	artifact := r.Storage.NewArtifactFor(bucket.Kind, bucket.GetObjectMeta(), revision, fmt.Sprintf("%s.tar.gz", revision))
-	if apimeta.IsStatusConditionTrue(bucket.Status.Conditions, meta.ReadyCondition) && bucket.GetArtifact().HasRevision(artifact.Revision) {
+	// return early on unchanged revision and format
+	fileName, contentType := ArchiveFileName(bucket.Spec.ArtifactFormat, revision)
+	artifact := r.Storage.NewArtifactFor(bucket.Kind, bucket.GetObjectMeta(), revision, fileName)
+	artifact.ContentType = contentType
+	if apimeta.IsStatusConditionTrue(bucket.Status.Conditions, meta.ReadyCondition) && bucket.GetArtifact().HasRevision(artifact.Revision) &&
+		bucket.GetArtifact().Path == artifact.Path {
 		if artifact.URL != bucket.GetArtifact().URL {
 			r.Storage.SetArtifactURL(bucket.GetArtifact())
 			bucket.Status.URL = r.Storage.SetHostname(bucket.Status.URL)
@@ -235,22 +286,58 @@ func (r *BucketReconciler) reconcile(ctx context.Context, bucket sourcev1.Bucket
 	defer unlock()
 
 	// archive artifact and check integrity
-	if err := r.Storage.Archive(&artifact, tempDir, nil); err != nil {
+	limits := r.Storage.ArtifactLimitsFor(bucket.Spec.ArtifactLimits)
+	if err := r.Storage.WithOrigin(bucketOrigin(bucket)).ArchiveWithLimits(&artifact, tempDir, nil, limits); err != nil {
+		reason := archiveErrorReason(err)
 		err = fmt.Errorf("storage archive error: %w", err)
-		return sourcev1.BucketNotReady(bucket, sourcev1.StorageOperationFailedReason, err.Error()), err
+		return sourcev1.BucketNotReady(bucket, reason, err.Error()), err
 	}
 
 	// update latest symlink
-	url, err := r.Storage.Symlink(artifact, "latest.tar.gz")
+	linkName, _ := ArchiveFileName(bucket.Spec.ArtifactFormat, "latest")
+	url, err := r.Storage.Symlink(artifact, linkName)
 	if err != nil {
 		err = fmt.Errorf("storage symlink error: %w", err)
 		return sourcev1.BucketNotReady(bucket, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
 
 	message := fmt.Sprintf("Fetched revision: %s", artifact.Revision)
+	if bucket.Spec.PointInTime != nil {
+		message += fmt.Sprintf(" at %s", bucket.Spec.PointInTime.UTC().Format(time.RFC3339))
+	}
+	bucket.Status.RetainedArtifacts = r.Storage.RetainArtifacts(bucket.Spec.ArtifactRetention, artifact, bucket.GetArtifact(),
+		bucket.Status.RetainedArtifacts)
 	return sourcev1.BucketReady(bucket, artifact, url, sourcev1.BucketOperationSucceedReason, message), nil
 }
 
+// bucketOrigin returns the URL of the given bucket, including the prefix, which is recorded as origin in the metadata
+// of its artifacts.
+func bucketOrigin(bucket sourcev1.Bucket) string {
+	origin := fmt.Sprintf("%s/%s", bucketEndpointURL(bucket), bucket.Spec.BucketName)
+	if prefix := bucketPrefix(bucket); prefix != "" {
+		origin += "/" + strings.TrimSuffix(prefix, "/")
+	}
+	return origin
+}
+
+// bucketPrefix returns the key prefix of the objects of the given bucket, which is either empty or ends with a '/'.
+func bucketPrefix(bucket sourcev1.Bucket) string {
+	prefix := strings.Trim(bucket.Spec.Prefix, "/")
+	if prefix == "" {
+		return ""
+	}
+	return prefix + "/"
+}
+
+// bucketEndpointURL returns the URL of the endpoint of the given bucket.
+func bucketEndpointURL(bucket sourcev1.Bucket) string {
+	scheme := "https"
+	if bucket.Spec.Insecure {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s", scheme, strings.TrimSuffix(bucket.Spec.Endpoint, "/"))
+}
+
 func (r *BucketReconciler) reconcileDelete(ctx context.Context, bucket sourcev1.Bucket) (ctrl.Result, error) {
 	if err := r.gc(bucket); err != nil {
 		r.event(ctx, bucket, events.EventSeverityError,
@@ -259,6 +346,13 @@ func (r *BucketReconciler) reconcileDelete(ctx context.Context, bucket sourcev1.
 		return ctrl.Result{}, err
 	}
 
+	// Remove the cached objects
+	if cacheDir := r.cacheDir(bucket); cacheDir != "" {
+		if err := os.RemoveAll(cacheDir); err != nil {
+			ctrl.LoggerFrom(ctx).Error(err, "failed to remove cache directory", "path", cacheDir)
+		}
+	}
+
 	// Record deleted status
 	r.recordReadiness(ctx, bucket)
 
@@ -272,21 +366,15 @@ func (r *BucketReconciler) reconcileDelete(ctx context.Context, bucket sourcev1.
 	return ctrl.Result{}, nil
 }
 
-// reconcileWithGCP handles getting objects from a Google Cloud Platform bucket
-// using a gcp client
-func (r *BucketReconciler) reconcileWithGCP(ctx context.Context, bucket sourcev1.Bucket, tempDir string) (sourcev1.Bucket, error) {
-	log := ctrl.LoggerFrom(ctx)
-	gcpClient, err := r.authGCP(ctx, bucket)
-	if err != nil {
-		err = fmt.Errorf("auth error: %w", err)
-		return sourcev1.BucketNotReady(bucket, sourcev1.AuthenticationFailedReason, err.Error()), err
-	}
-	defer gcpClient.Close(log)
-
+// reconcileWithProvider handles getting the objects from the bucket using the
+// given provider into the given cache. Objects which are cached with the ETag
+// they have in the bucket are not downloaded again.
+func (r *BucketReconciler) reconcileWithProvider(ctx context.Context, bucket sourcev1.Bucket,
+	provider objectstore.BucketProvider, cache *bucketCache) (sourcev1.Bucket, error) {
 	ctxTimeout, cancel := context.WithTimeout(ctx, bucket.Spec.Timeout.Duration)
 	defer cancel()
 
-	exists, err := gcpClient.BucketExists(ctxTimeout, bucket.Spec.BucketName)
+	exists, err := provider.BucketExists(ctxTimeout, bucket.Spec.BucketName)
 	if err != nil {
 		return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
 	}
@@ -295,14 +383,76 @@ func (r *BucketReconciler) reconcileWithGCP(ctx context.Context, bucket sourcev1
 		return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
 	}
 
-	// Look for file with ignore rules first.
-	path := filepath.Join(tempDir, sourceignore.IgnoreFile)
-	if err := gcpClient.FGetObject(ctxTimeout, bucket.Spec.BucketName, sourceignore.IgnoreFile, path); err != nil {
-		if err == gcp.ErrorObjectDoesNotExist && sourceignore.IgnoreFile != ".sourceignore" {
+	// List the objects with their key relative to the prefix, as they were
+	// at the point in time if set
+	prefix := bucketPrefix(bucket)
+	var objects []objectstore.ObjectInfo
+	visit := func(object objectstore.ObjectInfo) error {
+		object.Key = strings.TrimPrefix(object.Key, prefix)
+		if object.Key != "" && !strings.HasSuffix(object.Key, "/") {
+			objects = append(objects, object)
+		}
+		return nil
+	}
+	var versioned objectstore.VersionedBucketProvider
+	if bucket.Spec.PointInTime != nil {
+		var ok bool
+		if versioned, ok = provider.(objectstore.VersionedBucketProvider); !ok {
+			err = fmt.Errorf("provider '%s' does not support object versions", bucket.Spec.Provider)
 			return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
 		}
+		err = versioned.VisitObjectsAt(ctxTimeout, bucket.Spec.BucketName, prefix, bucket.Spec.PointInTime.Time, visit)
+	} else {
+		err = provider.VisitObjects(ctxTimeout, bucket.Spec.BucketName, prefix, visit)
+	}
+	if err != nil {
+		err = fmt.Errorf("listing objects from bucket '%s' failed: %w", bucket.Spec.BucketName, err)
+		return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
+	}
+
+	// Save the index on return, so that the objects downloaded before any
+	// failure are not downloaded again.
+	defer func() {
+		if err := cache.save(); err != nil {
+			ctrl.LoggerFrom(ctx).Error(err, "failed to save bucket cache index")
+		}
+	}()
+	download := func(ctx context.Context, object objectstore.ObjectInfo) error {
+		if cache.fresh(object) {
+			return nil
+		}
+		return retryOnTransientError(ctx, provider.IsPermanentError, func() error {
+			return cache.put(object, func(path string) error {
+				if object.VersionID != "" {
+					return versioned.FGetObjectVersion(ctx, bucket.Spec.BucketName, prefix+object.Key, object.VersionID, path)
+				}
+				return provider.FGetObject(ctx, bucket.Spec.BucketName, prefix+object.Key, path)
+			})
+		})
+	}
+
+	// Look for files with ignore rules first, in every directory
+	// NB: S3 has flat filepath keys making it impossible to look
+	// for files in "subdirectories" without building up a tree first.
+	keep := make(map[string]struct{})
+	var ignoreFiles []objectstore.ObjectInfo
+	for _, object := range objects {
+		if path.Base(object.Key) == sourceignore.IgnoreFile {
+			ignoreFiles = append(ignoreFiles, object)
+			keep[object.Key] = struct{}{}
+		}
+	}
+	err = r.downloadObjects(ctxTimeout, ignoreFiles, func(ctx context.Context, object objectstore.ObjectInfo) error {
+		if err := download(ctx, object); err != nil && !provider.ObjectIsNotFound(err) {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("downloading object from bucket '%s' failed: %w", bucket.Spec.BucketName, err)
+		return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
 	}
-	ps, err := sourceignore.ReadIgnoreFile(path, nil)
+	ps, err := readIgnoreFiles(cache, ignoreFiles)
 	if err != nil {
 		return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
 	}
@@ -311,108 +461,173 @@ func (r *BucketReconciler) reconcileWithGCP(ctx context.Context, bucket sourcev1
 		ps = append(ps, sourceignore.ReadPatterns(strings.NewReader(*bucket.Spec.Ignore), nil)...)
 	}
 	matcher := sourceignore.NewMatcher(ps)
-	objects := gcpClient.ListObjects(ctxTimeout, bucket.Spec.BucketName, nil)
-	// download bucket content
-	for {
-		object, err := objects.Next()
-		if err == gcp.IteratorDone {
-			break
-		}
-		if err != nil {
-			err = fmt.Errorf("listing objects from bucket '%s' failed: %w", bucket.Spec.BucketName, err)
-			return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
-		}
+	limiter := NewArtifactLimiter(r.Storage.ArtifactLimitsFor(bucket.Spec.ArtifactLimits))
 
-		if strings.HasSuffix(object.Name, "/") || object.Name == sourceignore.IgnoreFile {
+	// select the objects to download, before downloading any of them
+	var selected []objectstore.ObjectInfo
+	for _, object := range objects {
+		if path.Base(object.Key) == sourceignore.IgnoreFile {
 			continue
 		}
 
-		if matcher.Match(strings.Split(object.Name, "/"), false) {
+		if matcher.Match(strings.Split(object.Key, "/"), false) {
 			continue
 		}
 
-		localPath := filepath.Join(tempDir, object.Name)
-		if err = gcpClient.FGetObject(ctxTimeout, bucket.Spec.BucketName, object.Name, localPath); err != nil {
-			err = fmt.Errorf("downloading object from bucket '%s' failed: %w", bucket.Spec.BucketName, err)
-			return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
+		if err := limiter.Add(object.Key, object.Size); err != nil {
+			return sourcev1.BucketNotReady(bucket, sourcev1.ArtifactLimitExceededReason, err.Error()), err
 		}
+		selected = append(selected, object)
+		keep[object.Key] = struct{}{}
 	}
-	return sourcev1.Bucket{}, nil
-}
-
-// reconcileWithMinio handles getting objects from an S3 compatible bucket
-// using a minio client
-func (r *BucketReconciler) reconcileWithMinio(ctx context.Context, bucket sourcev1.Bucket, tempDir string) (sourcev1.Bucket, error) {
-	s3Client, err := r.authMinio(ctx, bucket)
-	if err != nil {
-		err = fmt.Errorf("auth error: %w", err)
-		return sourcev1.BucketNotReady(bucket, sourcev1.AuthenticationFailedReason, err.Error()), err
-	}
-
-	ctxTimeout, cancel := context.WithTimeout(ctx, bucket.Spec.Timeout.Duration)
-	defer cancel()
 
-	exists, err := s3Client.BucketExists(ctxTimeout, bucket.Spec.BucketName)
-	if err != nil {
-		return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
+	// download the objects that changed
+	if err := cache.prune(keep); err != nil {
+		err = fmt.Errorf("pruning bucket cache failed: %w", err)
+		return sourcev1.BucketNotReady(bucket, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
-	if !exists {
-		err = fmt.Errorf("bucket '%s' not found", bucket.Spec.BucketName)
+	if err := r.downloadObjects(ctxTimeout, selected, download); err != nil {
+		err = fmt.Errorf("downloading object from bucket '%s' failed: %w", bucket.Spec.BucketName, err)
 		return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
 	}
+	return sourcev1.Bucket{}, nil
+}
 
-	// Look for file with ignore rules first
-	// NB: S3 has flat filepath keys making it impossible to look
-	// for files in "subdirectories" without building up a tree first.
-	path := filepath.Join(tempDir, sourceignore.IgnoreFile)
-	if err := s3Client.FGetObject(ctxTimeout, bucket.Spec.BucketName, sourceignore.IgnoreFile, path, minio.GetObjectOptions{}); err != nil {
-		if resp, ok := err.(minio.ErrorResponse); ok && resp.Code != "NoSuchKey" {
-			return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
+// readIgnoreFiles reads the patterns of the given ignore files from the cache,
+// scoped to the directory of each file. The patterns are returned in the
+// order sourceignore.LoadIgnorePatterns loads them from a directory, so that
+// the patterns of a nested ignore file take precedence over those of its
+// parent directories.
+func readIgnoreFiles(cache *bucketCache, ignoreFiles []objectstore.ObjectInfo) ([]gitignore.Pattern, error) {
+	domains := make(map[string][]string, len(ignoreFiles))
+	keys := make([]string, 0, len(ignoreFiles))
+	for _, object := range ignoreFiles {
+		var domain []string
+		if dir := path.Dir(object.Key); dir != "." {
+			domain = strings.Split(dir, "/")
+		}
+		domains[object.Key] = domain
+		keys = append(keys, object.Key)
+	}
+	// A directory is walked before its subdirectories, which are walked in
+	// lexical order.
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := domains[keys[i]], domains[keys[j]]
+		for k := 0; k < len(a) && k < len(b); k++ {
+			if a[k] != b[k] {
+				return a[k] < b[k]
+			}
+		}
+		return len(a) < len(b)
+	})
+
+	var ps []gitignore.Pattern
+	for _, key := range keys {
+		p, err := cache.objectPath(key)
+		if err != nil {
+			return nil, err
 		}
+		filePatterns, err := sourceignore.ReadIgnoreFile(p, domains[key])
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, filePatterns...)
 	}
-	ps, err := sourceignore.ReadIgnoreFile(path, nil)
-	if err != nil {
-		return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
-	}
-	// In-spec patterns take precedence
-	if bucket.Spec.Ignore != nil {
-		ps = append(ps, sourceignore.ReadPatterns(strings.NewReader(*bucket.Spec.Ignore), nil)...)
-	}
-	matcher := sourceignore.NewMatcher(ps)
+	return ps, nil
+}
 
-	// download bucket content
-	for object := range s3Client.ListObjects(ctxTimeout, bucket.Spec.BucketName, minio.ListObjectsOptions{
-		Recursive: true,
-		UseV1:     s3utils.IsGoogleEndpoint(*s3Client.EndpointURL()),
-	}) {
-		if object.Err != nil {
-			err = fmt.Errorf("listing objects from bucket '%s' failed: %w", bucket.Spec.BucketName, object.Err)
-			return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
+// downloadObjects downloads the given objects with the given function, with
+// the configured number of concurrent workers. The first error it encounters
+// cancels all other workers.
+func (r *BucketReconciler) downloadObjects(ctx context.Context, objects []objectstore.ObjectInfo,
+	download func(ctx context.Context, object objectstore.ObjectInfo) error) error {
+	concurrent := int64(r.DownloadConcurrency)
+	if concurrent <= 0 {
+		concurrent = 1
+	}
+
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.Go(func() error {
+		sem := semaphore.NewWeighted(concurrent)
+		for _, object := range objects {
+			object := object
+			if err := sem.Acquire(groupCtx, 1); err != nil {
+				return err
+			}
+			group.Go(func() error {
+				defer sem.Release(1)
+				if err := download(groupCtx, object); err != nil {
+					return fmt.Errorf("object '%s': %w", object.Key, err)
+				}
+				return nil
+			})
 		}
+		return nil
+	})
+	return group.Wait()
+}
 
-		if strings.HasSuffix(object.Key, "/") || object.Key == sourceignore.IgnoreFile {
-			continue
-		}
+// retryOnTransientError calls the given function until it succeeds, with
+// bucketDownloadBackoff between the calls. Errors for which the given
+// function returns true, and errors after the context is done, are not
+// retried. The last error is returned once the retries are exhausted.
+func retryOnTransientError(ctx context.Context, permanent func(err error) bool, fn func() error) error {
+	var lastErr error
+	err := wait.ExponentialBackoffWithContext(ctx, bucketDownloadBackoff, func() (bool, error) {
+		lastErr = fn()
+		if lastErr == nil {
+			return true, nil
+		}
+		if permanent(lastErr) || ctx.Err() != nil {
+			return false, lastErr
+		}
+		return false, nil
+	})
+	if err != nil && lastErr != nil {
+		return lastErr
+	}
+	return err
+}
 
-		if matcher.Match(strings.Split(object.Key, "/"), false) {
-			continue
+// newProvider returns the objectstore.BucketProvider for the provider of
+// the given bucket, authenticated with the credentials of the bucket.
+func (r *BucketReconciler) newProvider(ctx context.Context, bucket sourcev1.Bucket) (objectstore.BucketProvider, error) {
+	// Return a nil interface rather than a nil client on errors.
+	switch bucket.Spec.Provider {
+	case sourcev1.GoogleBucketProvider:
+		client, err := r.authGCP(ctx, bucket)
+		if err != nil {
+			return nil, err
 		}
-
-		localPath := filepath.Join(tempDir, object.Key)
-		err := s3Client.FGetObject(ctxTimeout, bucket.Spec.BucketName, object.Key, localPath, minio.GetObjectOptions{})
+		return client, nil
+	case sourcev1.AzureBucketProvider:
+		client, err := r.authAzure(ctx, bucket)
 		if err != nil {
-			err = fmt.Errorf("downloading object from bucket '%s' failed: %w", bucket.Spec.BucketName, err)
-			return sourcev1.BucketNotReady(bucket, sourcev1.BucketOperationFailedReason, err.Error()), err
+			return nil, err
 		}
+		return client, nil
+	default:
+		client, err := r.authMinio(ctx, bucket)
+		if err != nil {
+			return nil, err
+		}
+		return objectstore.NewMinioProvider(client), nil
 	}
-	return sourcev1.Bucket{}, nil
+}
+
+// cacheDir returns the directory in which the objects of the given bucket are
+// cached, or an empty string if caching is disabled.
+func (r *BucketReconciler) cacheDir(bucket sourcev1.Bucket) string {
+	if r.CachePath == "" {
+		return ""
+	}
+	return filepath.Join(r.CachePath, bucket.GetNamespace(), bucket.GetName())
 }
 
 // authGCP creates a new Google Cloud Platform storage client
 // to interact with the storage service.
 func (r *BucketReconciler) authGCP(ctx context.Context, bucket sourcev1.Bucket) (*gcp.GCPClient, error) {
-	var client *gcp.GCPClient
-	var err error
+	var opts []option.ClientOption
 	if bucket.Spec.SecretRef != nil {
 		secretName := types.NamespacedName{
 			Namespace: bucket.GetNamespace(),
@@ -426,18 +641,35 @@ func (r *BucketReconciler) authGCP(ctx context.Context, bucket sourcev1.Bucket)
 		if err := gcp.ValidateSecret(secret.Data, secret.Name); err != nil {
 			return nil, err
 		}
-		client, err = gcp.NewClient(ctx, option.WithCredentialsJSON(secret.Data["serviceaccount"]))
-		if err != nil {
-			return nil, err
+		opts = append(opts, option.WithCredentialsJSON(secret.Data["serviceaccount"]))
+	} else if bucket.Spec.Insecure {
+		// Do not send the workload identity of the controller in plain text,
+		// but allow connecting to emulators which do not authenticate.
+		opts = append(opts, option.WithoutAuthentication())
+	}
+	return gcp.NewClientForEndpoint(ctx, bucketEndpointURL(bucket), opts...)
+}
+
+// authAzure creates a new Azure Blob Storage client to interact
+// with the storage account of the bucket endpoint.
+func (r *BucketReconciler) authAzure(ctx context.Context, bucket sourcev1.Bucket) (*azure.BlobClient, error) {
+	var data map[string][]byte
+	if bucket.Spec.SecretRef != nil {
+		secretName := types.NamespacedName{
+			Namespace: bucket.GetNamespace(),
+			Name:      bucket.Spec.SecretRef.Name,
 		}
-	} else {
-		client, err = gcp.NewClient(ctx)
-		if err != nil {
+
+		var secret corev1.Secret
+		if err := r.Get(ctx, secretName, &secret); err != nil {
+			return nil, fmt.Errorf("credentials secret error: %w", err)
+		}
+		if err := azure.ValidateSecret(secret.Data, secret.Name); err != nil {
 			return nil, err
 		}
+		data = secret.Data
 	}
-	return client, nil
-
+	return azure.NewClient(ctx, bucketEndpointURL(bucket), data)
 }
 
 // authMinio creates a new Minio client to interact with S3
@@ -448,30 +680,53 @@ func (r *BucketReconciler) authMinio(ctx context.Context, bucket sourcev1.Bucket
 		Secure: !bucket.Spec.Insecure,
 	}
 
+	var secret corev1.Secret
+	var tlsConfig *tls.Config
 	if bucket.Spec.SecretRef != nil {
 		secretName := types.NamespacedName{
 			Namespace: bucket.GetNamespace(),
 			Name:      bucket.Spec.SecretRef.Name,
 		}
 
-		var secret corev1.Secret
 		if err := r.Get(ctx, secretName, &secret); err != nil {
 			return nil, fmt.Errorf("credentials secret error: %w", err)
 		}
 
-		accesskey := ""
-		secretkey := ""
-		if k, ok := secret.Data["accesskey"]; ok {
-			accesskey = string(k)
+		var err error
+		if tlsConfig, err = objectstore.TLSClientConfig(secret.Data); err != nil {
+			return nil, fmt.Errorf("invalid '%s' secret data: %w", secret.Name, err)
+		}
+		if tlsConfig != nil && opt.Secure {
+			transport, err := minio.DefaultTransport(opt.Secure)
+			if err != nil {
+				return nil, err
+			}
+			transport.TLSClientConfig = tlsConfig
+			opt.Transport = transport
 		}
-		if k, ok := secret.Data["secretkey"]; ok {
-			secretkey = string(k)
+	}
+
+	accesskey := ""
+	secretkey := ""
+	if k, ok := secret.Data["accesskey"]; ok {
+		accesskey = string(k)
+	}
+	if k, ok := secret.Data["secretkey"]; ok {
+		secretkey = string(k)
+	}
+	switch {
+	case bucket.Spec.STS != nil:
+		creds, err := r.stsCredentials(bucket, accesskey, secretkey, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("STS error: %w", err)
 		}
+		opt.Creds = creds
+	case bucket.Spec.SecretRef != nil:
 		if accesskey == "" || secretkey == "" {
 			return nil, fmt.Errorf("invalid '%s' secret data: required fields 'accesskey' and 'secretkey'", secret.Name)
 		}
 		opt.Creds = credentials.NewStaticV4(accesskey, secretkey, "")
-	} else if bucket.Spec.Provider == sourcev1.AmazonBucketProvider {
+	case bucket.Spec.Provider == sourcev1.AmazonBucketProvider:
 		opt.Creds = credentials.NewIAM("")
 	}
 
@@ -482,32 +737,60 @@ func (r *BucketReconciler) authMinio(ctx context.Context, bucket sourcev1.Bucket
 	return minio.New(bucket.Spec.Endpoint, &opt)
 }
 
-// checksum calculates the SHA1 checksum of the given root directory.
-// It traverses the given root directory and calculates the checksum for any found file, and returns the SHA1 sum of the
-// list with relative file paths and their checksums.
-func (r *BucketReconciler) checksum(root string) (string, error) {
-	sum := sha1.New()
-	if err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.Mode().IsRegular() {
-			return nil
-		}
-		data, err := os.ReadFile(path)
-		if err != nil {
-			return err
-		}
-		relPath, err := filepath.Rel(root, path)
-		if err != nil {
-			return err
+// stsCredentials returns the credentials of the role assumed with the
+// Security Token Service of the given bucket, either with the given access
+// and secret key, or with the web identity token file of the reconciler.
+// The STS is verified with the given TLS configuration, if not nil.
+func (r *BucketReconciler) stsCredentials(bucket sourcev1.Bucket, accesskey, secretkey string,
+	tlsConfig *tls.Config) (*credentials.Credentials, error) {
+	sts := bucket.Spec.STS
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig != nil {
+		transport.TLSClientConfig = tlsConfig
+	}
+	client := &http.Client{Transport: transport}
+	sessionName := sts.RoleSessionName
+	if sessionName == "" {
+		sessionName = fmt.Sprintf("%s.%s", bucket.GetNamespace(), bucket.GetName())
+		// Role session names are limited to 64 characters.
+		if len(sessionName) > 64 {
+			sessionName = sessionName[:64]
+		}
+	}
+
+	if sts.WebIdentity {
+		if r.STSWebIdentityTokenFile == "" {
+			return nil, fmt.Errorf("no web identity token file configured")
+		}
+		if !r.webIdentityRoleAllowed(bucket.GetNamespace(), sts.RoleARN) {
+			return nil, fmt.Errorf("role '%s' is not allowed to be assumed with the web identity of the controller "+
+				"in namespace '%s'", sts.RoleARN, bucket.GetNamespace())
+		}
+		return objectstore.NewSTSWebIdentity(client, sts.Endpoint, r.STSWebIdentityTokenFile, sts.RoleARN, sessionName), nil
+	}
+	if accesskey == "" || secretkey == "" {
+		return nil, fmt.Errorf("AssumeRole requires a secret with the fields 'accesskey' and 'secretkey'")
+	}
+	return objectstore.NewSTSAssumeRole(client, sts.Endpoint, credentials.STSAssumeRoleOptions{
+		AccessKey:       accesskey,
+		SecretKey:       secretkey,
+		Location:        bucket.Spec.Region,
+		RoleARN:         sts.RoleARN,
+		RoleSessionName: sessionName,
+	})
+}
+
+// webIdentityRoleAllowed returns true if the role with the given ARN is in the
+// STSWebIdentityRoles of the given namespace, or of any namespace.
+func (r *BucketReconciler) webIdentityRoleAllowed(namespace, roleARN string) bool {
+	for _, ns := range []string{namespace, "*"} {
+		for _, allowed := range r.STSWebIdentityRoles[ns] {
+			if allowed == roleARN {
+				return true
+			}
 		}
-		sum.Write([]byte(fmt.Sprintf("%x  %s\n", sha1.Sum(data), relPath)))
-		return nil
-	}); err != nil {
-		return "", err
 	}
-	return fmt.Sprintf("%x", sum.Sum(nil)), nil
+	return false
 }
 
 // resetStatus returns a modified v1beta1.Bucket and a boolean indicating
@@ -526,7 +809,7 @@ func (r *BucketReconciler) resetStatus(bucket sourcev1.Bucket) (sourcev1.Bucket,
 }
 
 // gc performs a garbage collection for the given v1beta1.Bucket.
-// It removes all but the current artifact except for when the
+// It removes all but the current and retained artifacts except for when the
 // deletion timestamp is set, which will result in the removal of
 // all artifacts for the resource.
 func (r *BucketReconciler) gc(bucket sourcev1.Bucket) error {
@@ -534,7 +817,7 @@ func (r *BucketReconciler) gc(bucket sourcev1.Bucket) error {
 		return r.Storage.RemoveAll(r.Storage.NewArtifactFor(bucket.Kind, bucket.GetObjectMeta(), "", "*"))
 	}
 	if bucket.GetArtifact() != nil {
-		return r.Storage.RemoveAllButCurrent(*bucket.GetArtifact())
+		return r.Storage.RemoveAllButCurrent(*bucket.GetArtifact(), bucket.Status.RetainedArtifacts...)
 	}
 	return nil
 }
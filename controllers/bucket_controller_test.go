@@ -17,66 +17,908 @@ limitations under the License.
 package controllers
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/minio/minio-go/v7"
+	corev1 "k8s.io/api/core/v1"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fluxcd/pkg/apis/meta"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/fluxcd/source-controller/pkg/azure"
+	"github.com/fluxcd/source-controller/pkg/objectstore"
+	"github.com/fluxcd/source-controller/pkg/sourceignore"
 )
 
-func TestBucketReconciler_checksum(t *testing.T) {
+func mockFile(root, path, content string) error {
+	filePath := filepath.Join(root, path)
+	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
+		panic(err)
+	}
+	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
+		panic(err)
+	}
+	return nil
+}
+
+func TestBucketReconciler_reconcileWithProvider(t *testing.T) {
+	root := t.TempDir()
+	mockFile(root, "podinfo/.sourceignore", "ignored/\n")
+	mockFile(root, "podinfo/deploy/manifest.yaml", "kind: ConfigMap")
+	mockFile(root, "podinfo/deploy/nested/secret.yaml", "kind: Secret")
+	mockFile(root, "podinfo/ignored/manifest.yaml", "kind: Pod")
+	mockFile(root, "podinfo/README.md", "ignored by spec")
+	mockFile(root, "monorepo/apps/.sourceignore", "*.txt\n")
+	mockFile(root, "monorepo/apps/app.yaml", "kind: Deployment")
+	mockFile(root, "monorepo/apps/nested/service.yaml", "kind: Service")
+	mockFile(root, "monorepo/apps/notes.txt", "ignored by prefixed .sourceignore")
+	mockFile(root, "monorepo/apps-other.yaml", "kind: Pod")
+	mockFile(root, "monorepo/.sourceignore", "*.yaml\n")
+	mockFile(root, "nested/.sourceignore", "*.txt\n")
+	mockFile(root, "nested/apps/.sourceignore", "!keep.txt\n/local.yaml\n")
+	mockFile(root, "nested/apps/keep.txt", "included by nested negation")
+	mockFile(root, "nested/apps/drop.txt", "ignored by root pattern")
+	mockFile(root, "nested/apps/local.yaml", "ignored by nested pattern")
+	mockFile(root, "nested/apps/deep/.sourceignore", "ignored.yaml\n")
+	mockFile(root, "nested/apps/deep/ignored.yaml", "ignored by deep pattern")
+	mockFile(root, "nested/apps/deep/app.yaml", "kind: Deployment")
+	mockFile(root, "nested/ignored.yaml", "not in the domain of the deep pattern")
+	mockFile(root, "nested/local.yaml", "not in the domain of the nested pattern")
+	provider := objectstore.NewLocalProvider(root)
+
+	tests := []struct {
+		name        string
+		bucketName  string
+		prefix      string
+		pointInTime *metav1.Time
+		limits      *sourcev1.ArtifactLimits
+		want        []string
+		wantReason  string
+	}{
+		{
+			name:       "downloads objects not ignored",
+			bucketName: "podinfo",
+			want:       []string{".sourceignore", "deploy/manifest.yaml", "deploy/nested/secret.yaml"},
+		},
+		{
+			name:       "downloads objects under prefix",
+			bucketName: "monorepo",
+			prefix:     "/apps/",
+			want:       []string{".sourceignore", "app.yaml", "nested/service.yaml"},
+		},
+		{
+			name:       "honors nested .sourceignore files",
+			bucketName: "nested",
+			want: []string{".sourceignore", "apps/.sourceignore", "apps/deep/.sourceignore", "apps/deep/app.yaml",
+				"apps/keep.txt", "ignored.yaml", "local.yaml"},
+		},
+		{
+			name:        "point in time not supported",
+			bucketName:  "podinfo",
+			pointInTime: &metav1.Time{Time: time.Now()},
+			wantReason:  sourcev1.BucketOperationFailedReason,
+		},
+		{
+			name:       "bucket not found",
+			bucketName: "does-not-exist",
+			wantReason: sourcev1.BucketOperationFailedReason,
+		},
+		{
+			name:       "artifact limits exceeded",
+			bucketName: "podinfo",
+			limits:     &sourcev1.ArtifactLimits{MaxFiles: 1},
+			wantReason: sourcev1.ArtifactLimitExceededReason,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ignore := "*.md"
+			bucket := sourcev1.Bucket{
+				Spec: sourcev1.BucketSpec{
+					BucketName:     tt.bucketName,
+					Prefix:         tt.prefix,
+					PointInTime:    tt.pointInTime,
+					Ignore:         &ignore,
+					Timeout:        &metav1.Duration{Duration: time.Minute},
+					ArtifactLimits: tt.limits,
+				},
+			}
+			cache, err := openBucketCache(t.TempDir(), "origin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := (&BucketReconciler{Storage: &Storage{}}).reconcileWithProvider(context.TODO(), bucket,
+				provider, cache)
+			if tt.wantReason != "" {
+				if err == nil {
+					t.Fatal("reconcileWithProvider() expected error")
+				}
+				if c := apimeta.FindStatusCondition(got.Status.Conditions, meta.ReadyCondition); c == nil ||
+					c.Reason != tt.wantReason {
+					t.Errorf("reconcileWithProvider() condition = %v, want reason %s", c, tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("reconcileWithProvider() error = %v", err)
+			}
+			if files := listFiles(cache.objectsDir()); strings.Join(files, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("reconcileWithProvider() downloaded %v, want %v", files, tt.want)
+			}
+		})
+	}
+}
+
+func Test_readIgnoreFiles(t *testing.T) {
+	cache, err := openBucketCache(t.TempDir(), "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ignoreFiles := map[string]string{
+		".sourceignore":       "*.txt\n",
+		"a/.sourceignore":     "!a.txt\n",
+		"a/b/.sourceignore":   "b.yaml\n",
+		"a-b/.sourceignore":   "/c.yaml\n",
+		"a.b/c/.sourceignore": "*.json\n",
+	}
+	var objects []objectstore.ObjectInfo
+	for key, content := range ignoreFiles {
+		if err := cache.put(objectstore.ObjectInfo{Key: key, ETag: "etag"}, func(path string) error {
+			return mockFile(filepath.Dir(path), filepath.Base(path), content)
+		}); err != nil {
+			t.Fatal(err)
+		}
+		objects = append(objects, objectstore.ObjectInfo{Key: key})
+	}
+
+	got, err := readIgnoreFiles(cache, objects)
+	if err != nil {
+		t.Fatalf("readIgnoreFiles() error = %v", err)
+	}
+	want, err := sourceignore.LoadIgnorePatterns(cache.objectsDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readIgnoreFiles() = %v, want patterns of LoadIgnorePatterns() %v", got, want)
+	}
+}
+
+// versionedProvider is a VersionedBucketProvider for a bucket with the given
+// object versions, which are written in the given order.
+type versionedProvider struct {
+	objectstore.BucketProvider
+	versions []objectVersion
+}
+
+// objectVersion is a version of an object in a versionedProvider.
+type objectVersion struct {
+	key, id, content string
+	modified         time.Time
+	deleteMarker     bool
+}
+
+func (p *versionedProvider) VisitObjectsAt(_ context.Context, _, _ string, at time.Time,
+	visit func(object objectstore.ObjectInfo) error) error {
+	latest := map[string]objectVersion{}
+	for _, v := range p.versions {
+		if !v.modified.After(at) {
+			latest[v.key] = v
+		}
+	}
+	for _, v := range latest {
+		if v.deleteMarker {
+			continue
+		}
+		if err := visit(objectstore.ObjectInfo{Key: v.key, Size: int64(len(v.content)), ETag: v.id, VersionID: v.id}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *versionedProvider) FGetObjectVersion(_ context.Context, _, objectKey, versionID, localPath string) error {
+	for _, v := range p.versions {
+		if v.key == objectKey && v.id == versionID {
+			return mockFile(filepath.Dir(localPath), filepath.Base(localPath), v.content)
+		}
+	}
+	return os.ErrNotExist
+}
+
+func TestBucketReconciler_reconcileWithProvider_pointInTime(t *testing.T) {
+	root := t.TempDir()
+	mockFile(root, "podinfo/deploy/manifest.yaml", "kind: ConfigMap")
+	base := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	provider := &versionedProvider{
+		BucketProvider: objectstore.NewLocalProvider(root),
+		versions: []objectVersion{
+			{key: "deploy/manifest.yaml", id: "1", content: "version: 1", modified: base},
+			{key: "deploy/removed.yaml", id: "2", content: "removed: false", modified: base},
+			{key: "deploy/manifest.yaml", id: "3", content: "version: 3", modified: base.Add(time.Hour)},
+			{key: "deploy/removed.yaml", id: "4", modified: base.Add(time.Hour), deleteMarker: true},
+		},
+	}
+	r := &BucketReconciler{Storage: &Storage{}}
+
+	tests := []struct {
+		name        string
+		pointInTime time.Time
+		want        map[string]string
+	}{
+		{
+			name:        "before latest versions",
+			pointInTime: base.Add(30 * time.Minute),
+			want:        map[string]string{"deploy/manifest.yaml": "version: 1", "deploy/removed.yaml": "removed: false"},
+		},
+		{
+			name:        "at latest versions",
+			pointInTime: base.Add(time.Hour),
+			want:        map[string]string{"deploy/manifest.yaml": "version: 3"},
+		},
+	}
+	revisions := map[string]bool{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bucket := sourcev1.Bucket{
+				Spec: sourcev1.BucketSpec{
+					BucketName:  "podinfo",
+					PointInTime: &metav1.Time{Time: tt.pointInTime},
+					Timeout:     &metav1.Duration{Duration: time.Minute},
+				},
+			}
+			cache, err := openBucketCache(t.TempDir(), "origin")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := r.reconcileWithProvider(context.TODO(), bucket, provider, cache); err != nil {
+				t.Fatalf("reconcileWithProvider() error = %v", err)
+			}
+			got := map[string]string{}
+			for _, file := range listFiles(cache.objectsDir()) {
+				b, err := os.ReadFile(filepath.Join(cache.objectsDir(), file))
+				if err != nil {
+					t.Fatal(err)
+				}
+				got[file] = string(b)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reconcileWithProvider() downloaded %v, want %v", got, tt.want)
+			}
+			revision, err := cache.revision()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revisions[revision] {
+				t.Errorf("revision() = %s, want unique revision per point in time", revision)
+			}
+			revisions[revision] = true
+		})
+	}
+}
+
+// countingProvider is a BucketProvider that counts the objects downloaded
+// through it.
+type countingProvider struct {
+	objectstore.BucketProvider
+	downloaded []string
+}
+
+func (p *countingProvider) FGetObject(ctx context.Context, bucketName, objectKey, localPath string) error {
+	p.downloaded = append(p.downloaded, objectKey)
+	return p.BucketProvider.FGetObject(ctx, bucketName, objectKey, localPath)
+}
+
+func TestBucketReconciler_reconcileWithProvider_incremental(t *testing.T) {
+	root := t.TempDir()
+	mockFile(root, "podinfo/deploy/manifest.yaml", "kind: ConfigMap")
+	mockFile(root, "podinfo/deploy/secret.yaml", "kind: Secret")
+	mockFile(root, "podinfo/deploy/pod.yaml", "kind: Pod")
+	provider := &countingProvider{BucketProvider: objectstore.NewLocalProvider(root)}
+	bucket := sourcev1.Bucket{
+		Spec: sourcev1.BucketSpec{
+			BucketName: "podinfo",
+			Timeout:    &metav1.Duration{Duration: time.Minute},
+		},
+	}
+	cacheDir := t.TempDir()
+	r := &BucketReconciler{Storage: &Storage{}}
+
+	reconcile := func() (string, []string) {
+		t.Helper()
+		provider.downloaded = nil
+		cache, err := openBucketCache(cacheDir, "origin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.reconcileWithProvider(context.TODO(), bucket, provider, cache); err != nil {
+			t.Fatalf("reconcileWithProvider() error = %v", err)
+		}
+		revision, err := cache.revision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return revision, provider.downloaded
+	}
+
+	revision, downloaded := reconcile()
+	if len(downloaded) != 3 {
+		t.Errorf("first reconcile downloaded %v, want all objects", downloaded)
+	}
+	if r, downloaded := reconcile(); len(downloaded) != 0 || r != revision {
+		t.Errorf("unchanged reconcile downloaded %v with revision %s, want none with revision %s", downloaded, r, revision)
+	}
+
+	// Change the modification time along with the content, as the ETag of
+	// the local provider is derived from it.
+	mockFile(root, "podinfo/deploy/secret.yaml", "kind: Secret\ntype: Opaque")
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(root, "podinfo/deploy/secret.yaml"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(root, "podinfo/deploy/pod.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	r2, downloaded := reconcile()
+	if strings.Join(downloaded, ",") != "deploy/secret.yaml" {
+		t.Errorf("changed reconcile downloaded %v, want deploy/secret.yaml", downloaded)
+	}
+	if r2 == revision {
+		t.Errorf("changed reconcile kept revision %s", revision)
+	}
+	if files := listFiles(filepath.Join(cacheDir, bucketCacheObjectsDir)); strings.Join(files, ",") != "deploy/manifest.yaml,deploy/secret.yaml" {
+		t.Errorf("changed reconcile left %v in cache", files)
+	}
+	b, err := os.ReadFile(filepath.Join(cacheDir, bucketCacheObjectsDir, "deploy/secret.yaml"))
+	if err != nil || string(b) != "kind: Secret\ntype: Opaque" {
+		t.Errorf("changed reconcile cached %q, %v", b, err)
+	}
+}
+
+func TestBucketReconciler_downloadObjects(t *testing.T) {
+	var objects []objectstore.ObjectInfo
+	for i := 0; i < 20; i++ {
+		objects = append(objects, objectstore.ObjectInfo{Key: fmt.Sprintf("object-%d", i)})
+	}
+
+	t.Run("bounds concurrent downloads", func(t *testing.T) {
+		var mu sync.Mutex
+		var running, maxRunning int
+		downloaded := map[string]bool{}
+		r := &BucketReconciler{DownloadConcurrency: 4}
+		err := r.downloadObjects(context.TODO(), objects, func(ctx context.Context, object objectstore.ObjectInfo) error {
+			mu.Lock()
+			running++
+			if running > maxRunning {
+				maxRunning = running
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			running--
+			downloaded[object.Key] = true
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("downloadObjects() error = %v", err)
+		}
+		if len(downloaded) != len(objects) {
+			t.Errorf("downloadObjects() downloaded %d objects, want %d", len(downloaded), len(objects))
+		}
+		if maxRunning < 2 || maxRunning > 4 {
+			t.Errorf("downloadObjects() ran %d downloads concurrently, want 2 to 4", maxRunning)
+		}
+	})
+
+	t.Run("cancels on first error", func(t *testing.T) {
+		var mu sync.Mutex
+		started := 0
+		r := &BucketReconciler{DownloadConcurrency: 2}
+		err := r.downloadObjects(context.TODO(), objects, func(ctx context.Context, object objectstore.ObjectInfo) error {
+			mu.Lock()
+			started++
+			mu.Unlock()
+			if object.Key == "object-1" {
+				return fmt.Errorf("access denied")
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Second):
+				return nil
+			}
+		})
+		if err == nil || err.Error() != "object 'object-1': access denied" {
+			t.Errorf("downloadObjects() error = %v, want access denied", err)
+		}
+		if started == len(objects) {
+			t.Error("downloadObjects() did not stop downloading after error")
+		}
+	})
+}
+
+func Test_retryOnTransientError(t *testing.T) {
+	backoff := bucketDownloadBackoff
+	bucketDownloadBackoff.Duration = time.Millisecond
+	defer func() { bucketDownloadBackoff = backoff }()
+
+	errTransient := fmt.Errorf("connection reset")
+	errPermanent := fmt.Errorf("not found")
+	permanent := func(err error) bool { return err == errPermanent }
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "succeeds",
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name:      "retries transient errors",
+			errs:      []error{errTransient, errTransient, nil},
+			wantCalls: 3,
+		},
+		{
+			name:      "does not retry permanent errors",
+			errs:      []error{errTransient, errPermanent},
+			wantCalls: 2,
+			wantErr:   errPermanent,
+		},
+		{
+			name:      "returns last error when exhausted",
+			errs:      []error{errTransient, errTransient, errTransient, errTransient, nil},
+			wantCalls: bucketDownloadBackoff.Steps,
+			wantErr:   errTransient,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := retryOnTransientError(context.TODO(), permanent, func() error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+			if err != tt.wantErr {
+				t.Errorf("retryOnTransientError() error = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("retryOnTransientError() called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+
+	ctx, cancel := context.WithCancel(context.TODO())
+	calls := 0
+	err := retryOnTransientError(ctx, permanent, func() error {
+		calls++
+		cancel()
+		return errTransient
+	})
+	if err != errTransient || calls != 1 {
+		t.Errorf("retryOnTransientError() after cancel = %v after %d calls, want %v after 1", err, calls, errTransient)
+	}
+}
+
+func TestBucketReconciler_authMinio_TLS(t *testing.T) {
+	ca, caPEM := generateCertificate(t, "ca", nil)
+	server, _ := generateCertificate(t, "127.0.0.1", &ca)
+	_, clientPEM := generateCertificate(t, "client", &ca)
+	caPool := x509.NewCertPool()
+	caPool.AddCert(ca.Leaf)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	ts.TLS = &tls.Config{
+		Certificates: []tls.Certificate{server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    caPool,
+	}
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.StartTLS()
+	defer ts.Close()
+
+	// Fail fast on the handshake errors.
+	maxRetry := minio.MaxRetry
+	minio.MaxRetry = 1
+	defer func() { minio.MaxRetry = maxRetry }()
+
 	tests := []struct {
 		name       string
-		beforeFunc func(root string)
-		want       string
-		wantErr    bool
+		data       map[string][]byte
+		wantErr    string
+		wantExists bool
 	}{
 		{
-			name: "empty root",
-			want: "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+			name: "CA and client certificate",
+			data: map[string][]byte{
+				objectstore.CAFileField:   caPEM[0],
+				objectstore.CertFileField: clientPEM[0],
+				objectstore.KeyFileField:  clientPEM[1],
+			},
+			wantExists: true,
+		},
+		{
+			name:    "CA without client certificate",
+			data:    map[string][]byte{objectstore.CAFileField: caPEM[0]},
+			wantErr: "certificate",
 		},
 		{
-			name: "with file",
-			beforeFunc: func(root string) {
-				mockFile(root, "a/b/c.txt", "a dummy string")
+			name: "client certificate without CA",
+			data: map[string][]byte{
+				objectstore.CertFileField: clientPEM[0],
+				objectstore.KeyFileField:  clientPEM[1],
 			},
-			want: "309a5e6e96b4a7eea0d1cfaabf1be8ec1c063fa0",
+			wantErr: "certificate signed by unknown authority",
 		},
 		{
-			name: "with file in different path",
-			beforeFunc: func(root string) {
-				mockFile(root, "a/b.txt", "a dummy string")
+			name:    "client certificate without key",
+			data:    map[string][]byte{objectstore.CertFileField: clientPEM[0]},
+			wantErr: "fields 'certFile' and 'keyFile' require each other's presence",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "minio-credentials"},
+				Data:       map[string][]byte{"accesskey": []byte("key"), "secretkey": []byte("secret")},
+			}
+			for k, v := range tt.data {
+				secret.Data[k] = v
+			}
+			scheme := runtime.NewScheme()
+			if err := corev1.AddToScheme(scheme); err != nil {
+				t.Fatal(err)
+			}
+			r := &BucketReconciler{
+				Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build(),
+			}
+			bucket := sourcev1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "minio"},
+				Spec: sourcev1.BucketSpec{
+					BucketName: "podinfo",
+					Endpoint:   ts.Listener.Addr().String(),
+					Region:     "us-east-1",
+					SecretRef:  &meta.LocalObjectReference{Name: secret.Name},
+				},
+			}
+
+			client, err := r.authMinio(context.TODO(), bucket)
+			var exists bool
+			if err == nil {
+				exists, err = objectstore.NewMinioProvider(client).BucketExists(context.TODO(), bucket.Spec.BucketName)
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("BucketExists() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || exists != tt.wantExists {
+				t.Errorf("BucketExists() = %v, %v, want %v", exists, err, tt.wantExists)
+			}
+		})
+	}
+}
+
+func TestBucketReconciler_authMinio_STS(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("token"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server acts as both the STS and the S3 endpoint, which only
+	// accepts the temporary credentials issued by the STS.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			if r.Header.Get("X-Amz-Security-Token") != "session" ||
+				!strings.Contains(r.Header.Get("Authorization"), "Credential=assumed/") {
+				w.WriteHeader(http.StatusForbidden)
+			}
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.Form.Get("RoleSessionName") != "default.minio" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.Form.Get("Action") {
+		case "AssumeRole":
+			if !strings.Contains(r.Header.Get("Authorization"), "Credential=key/") {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		case "AssumeRoleWithWebIdentity":
+			if r.Form.Get("WebIdentityToken") != "token" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+		action := r.Form.Get("Action")
+		fmt.Fprintf(w, `<%[1]sResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><%[1]sResult><Credentials>
+<AccessKeyId>assumed</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>session</SessionToken>
+<Expiration>%[2]s</Expiration></Credentials></%[1]sResult></%[1]sResponse>`,
+			action, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer ts.Close()
+
+	maxRetry := minio.MaxRetry
+	minio.MaxRetry = 1
+	defer func() { minio.MaxRetry = maxRetry }()
+
+	const roleARN = "arn:aws:iam::123456789012:role/podinfo-reader"
+	tests := []struct {
+		name        string
+		webIdentity bool
+		tokenFile   string
+		roles       map[string][]string
+		data        map[string][]byte
+		wantErr     string
+	}{
+		{
+			name: "AssumeRole",
+			data: map[string][]byte{"accesskey": []byte("key"), "secretkey": []byte("secret")},
+		},
+		{
+			name:    "AssumeRole without secret keys",
+			data:    map[string][]byte{"accesskey": []byte("key")},
+			wantErr: "AssumeRole requires a secret with the fields 'accesskey' and 'secretkey'",
+		},
+		{
+			name:        "AssumeRoleWithWebIdentity",
+			webIdentity: true,
+			tokenFile:   tokenFile,
+			roles:       map[string][]string{"default": {roleARN}},
+		},
+		{
+			name:        "AssumeRoleWithWebIdentity with role allowed in any namespace",
+			webIdentity: true,
+			tokenFile:   tokenFile,
+			roles:       map[string][]string{"*": {roleARN}},
+		},
+		{
+			name:        "AssumeRoleWithWebIdentity with disallowed role",
+			webIdentity: true,
+			tokenFile:   tokenFile,
+			roles: map[string][]string{
+				"default": {"arn:aws:iam::123456789012:role/other"},
+				"other":   {roleARN},
 			},
-			want: "e28c62b5cc488849950c4355dddc5523712616d4",
+			wantErr: "role '" + roleARN + "' is not allowed to be assumed with the web identity of the controller in namespace 'default'",
+		},
+		{
+			name:        "AssumeRoleWithWebIdentity without token file",
+			webIdentity: true,
+			roles:       map[string][]string{"default": {roleARN}},
+			wantErr:     "no web identity token file configured",
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			root, err := os.MkdirTemp("", "bucket-checksum-")
-			if err != nil {
+			scheme := runtime.NewScheme()
+			if err := corev1.AddToScheme(scheme); err != nil {
 				t.Fatal(err)
 			}
-			defer os.RemoveAll(root)
-			if tt.beforeFunc != nil {
-				tt.beforeFunc(root)
+			builder := fake.NewClientBuilder().WithScheme(scheme)
+			bucket := sourcev1.Bucket{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "minio"},
+				Spec: sourcev1.BucketSpec{
+					BucketName: "podinfo",
+					Endpoint:   ts.Listener.Addr().String(),
+					Insecure:   true,
+					Region:     "us-east-1",
+					STS: &sourcev1.BucketSTSSpec{
+						Endpoint:    ts.URL,
+						RoleARN:     roleARN,
+						WebIdentity: tt.webIdentity,
+					},
+				},
+			}
+			if tt.data != nil {
+				builder.WithObjects(&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "minio-credentials"},
+					Data:       tt.data,
+				})
+				bucket.Spec.SecretRef = &meta.LocalObjectReference{Name: "minio-credentials"}
+			}
+			r := &BucketReconciler{
+				Client:                  builder.Build(),
+				STSWebIdentityTokenFile: tt.tokenFile,
+				STSWebIdentityRoles:     tt.roles,
+			}
+
+			client, err := r.authMinio(context.TODO(), bucket)
+			var exists bool
+			if err == nil {
+				exists, err = objectstore.NewMinioProvider(client).BucketExists(context.TODO(), bucket.Spec.BucketName)
 			}
-			got, err := (&BucketReconciler{}).checksum(root)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("checksum() error = %v, wantErr %v", err, tt.wantErr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("authMinio() error = %v, want %q", err, tt.wantErr)
+				}
 				return
 			}
-			if got != tt.want {
-				t.Errorf("checksum() got = %v, want %v", got, tt.want)
+			if err != nil || !exists {
+				t.Errorf("BucketExists() = %v, %v, want true", exists, err)
 			}
 		})
 	}
 }
 
-func mockFile(root, path, content string) error {
-	filePath := filepath.Join(root, path)
-	if err := os.MkdirAll(filepath.Dir(filePath), os.ModePerm); err != nil {
-		panic(err)
+// generateCertificate returns a certificate for the given common name, which
+// is also its IP address if it is one, signed by the given parent, or
+// self-signed as CA if the parent is nil. It also returns the PEM encoded
+// certificate and key.
+func generateCertificate(t *testing.T, commonName string, parent *tls.Certificate) (tls.Certificate, [][]byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if err := os.WriteFile(filePath, []byte(content), 0644); err != nil {
-		panic(err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if ip := net.ParseIP(commonName); ip != nil {
+		template.IPAddresses = []net.IP{ip}
+	}
+	signer, signerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(der); err != nil {
+		t.Fatal(err)
+	}
+	return cert, [][]byte{certPEM, keyPEM}
+}
+
+// listFiles returns the forward slash separated paths of the regular files
+// in the given directory, relative to it, in lexical order.
+func listFiles(dir string) []string {
+	var files []string
+	filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err == nil && fi.Mode().IsRegular() {
+			rel, _ := filepath.Rel(dir, p)
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return err
+	})
+	return files
+}
+
+func TestBucketReconciler_reconcileWithAzurite(t *testing.T) {
+	// The endpoint of the Azurite emulator, e.g. '127.0.0.1:10000/devstoreaccount1'.
+	endpoint := os.Getenv("AZURITE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("AZURITE_BLOB_ENDPOINT is not set")
+	}
+	// The well-known shared key of the Azurite emulator.
+	accountKey := "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "azure-credentials"},
+		Data:       map[string][]byte{azure.AccountKeyField: []byte(accountKey)},
+	}
+	azureClient, err := azure.NewClient(context.TODO(), "http://"+endpoint, secret.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containerName := fmt.Sprintf("bucket-%d", time.Now().UnixNano())
+	containerURL := azureClient.NewContainerURL(containerName)
+	if _, err := containerURL.Create(context.TODO(), nil, azblob.PublicAccessNone); err != nil {
+		t.Fatal(err)
+	}
+	defer containerURL.Delete(context.TODO(), azblob.ContainerAccessConditions{})
+	for name, content := range map[string]string{
+		".sourceignore":         "ignored/",
+		"deploy/manifest.yaml":  "kind: ConfigMap",
+		"ignored/manifest.yaml": "kind: Pod",
+		"spec-ignored.txt":      "ignored by spec",
+	} {
+		if _, err := azblob.UploadBufferToBlockBlob(context.TODO(), []byte(content),
+			containerURL.NewBlockBlobURL(name), azblob.UploadToBlockBlobOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scheme := runtime.NewScheme()
+	if err := corev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	ignore := "*.txt"
+	bucket := sourcev1.Bucket{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "azure"},
+		Spec: sourcev1.BucketSpec{
+			Provider:   sourcev1.AzureBucketProvider,
+			BucketName: containerName,
+			Endpoint:   endpoint,
+			Insecure:   true,
+			SecretRef:  &meta.LocalObjectReference{Name: secret.Name},
+			Ignore:     &ignore,
+			Timeout:    &metav1.Duration{Duration: time.Minute},
+		},
+	}
+	r := &BucketReconciler{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).WithObjects(secret).Build(),
+		Storage: &Storage{},
+	}
+
+	provider, err := r.newProvider(context.TODO(), bucket)
+	if err != nil {
+		t.Fatalf("newProvider() error = %v", err)
+	}
+	cache, err := openBucketCache(t.TempDir(), "origin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.reconcileWithProvider(context.TODO(), bucket, provider, cache); err != nil {
+		t.Fatalf("reconcileWithProvider() error = %v", err)
+	}
+	got := listFiles(cache.objectsDir())
+	if want := ".sourceignore,deploy/manifest.yaml"; strings.Join(got, ",") != want {
+		t.Errorf("reconcileWithProvider() downloaded %v, want %s", got, want)
+	}
+	for key, e := range cache.index.Objects {
+		if e.ETag == "" {
+			t.Errorf("reconcileWithProvider() cached %s without ETag", key)
+		}
+	}
+
+	bucket.Spec.BucketName = "does-not-exist"
+	if _, err := r.reconcileWithProvider(context.TODO(), bucket, provider, cache); err == nil ||
+		!strings.Contains(err.Error(), "not found") {
+		t.Errorf("reconcileWithProvider() error = %v, want bucket not found", err)
 	}
-	return nil
 }
@@ -164,7 +164,11 @@ func (r *GitRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		repository.Status.SetLastHandledReconcileRequest(v)
 	}
 
-	// purge old artifacts from storage
+	// apply the artifact retention policy and purge old artifacts from storage
+	if artifact := repository.GetArtifact(); artifact != nil {
+		repository.Status.RetainedArtifacts = r.Storage.RetainArtifacts(repository.Spec.ArtifactRetention, *artifact, nil,
+			repository.Status.RetainedArtifacts)
+	}
 	if err := r.gc(repository); err != nil {
 		log.Error(err, "unable to purge old artifacts")
 	}
@@ -196,7 +200,7 @@ func (r *GitRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		repository.GetInterval().Duration.String(),
 	))
 
-	return ctrl.Result{RequeueAfter: repository.GetInterval().Duration}, nil
+	return ctrl.Result{RequeueAfter: r.Storage.RequeueAfter(repository.GetInterval().Duration)}, nil
 }
 
 func (r *GitRepositoryReconciler) checkDependencies(repository sourcev1.GitRepository) error {
@@ -275,7 +279,9 @@ func (r *GitRepositoryReconciler) reconcile(ctx context.Context, repository sour
 	if err != nil {
 		return sourcev1.GitRepositoryNotReady(repository, sourcev1.GitOperationFailedReason, err.Error()), err
 	}
-	artifact := r.Storage.NewArtifactFor(repository.Kind, repository.GetObjectMeta(), commit.String(), fmt.Sprintf("%s.tar.gz", commit.Hash.String()))
+	fileName, contentType := ArchiveFileName(repository.Spec.ArtifactFormat, commit.Hash.String())
+	artifact := r.Storage.NewArtifactFor(repository.Kind, repository.GetObjectMeta(), commit.String(), fileName)
+	artifact.ContentType = contentType
 
 	// copy all included repository into the artifact
 	includedArtifacts := []*sourcev1.Artifact{}
@@ -289,8 +295,9 @@ func (r *GitRepositoryReconciler) reconcile(ctx context.Context, repository sour
 		includedArtifacts = append(includedArtifacts, gr.GetArtifact())
 	}
 
-	// return early on unchanged revision and unchanged included repositories
-	if apimeta.IsStatusConditionTrue(repository.Status.Conditions, meta.ReadyCondition) && repository.GetArtifact().HasRevision(artifact.Revision) && !hasArtifactUpdated(repository.Status.IncludedArtifacts, includedArtifacts) {
+	// return early on unchanged revision, format and included repositories
+	if apimeta.IsStatusConditionTrue(repository.Status.Conditions, meta.ReadyCondition) && repository.GetArtifact().HasRevision(artifact.Revision) &&
+		repository.GetArtifact().Path == artifact.Path && !hasArtifactUpdated(repository.Status.IncludedArtifacts, includedArtifacts) {
 		if artifact.URL != repository.GetArtifact().URL {
 			r.Storage.SetArtifactURL(repository.GetArtifact())
 			repository.Status.URL = r.Storage.SetHostname(repository.Status.URL)
@@ -345,32 +352,48 @@ func (r *GitRepositoryReconciler) reconcile(ctx context.Context, repository sour
 	}
 	defer unlock()
 
-	// archive artifact and check integrity
-	ignoreDomain := strings.Split(tmpGit, string(filepath.Separator))
-	ps, err := sourceignore.LoadIgnorePatterns(tmpGit, ignoreDomain)
+	// archive artifact within the artifact limits and check integrity,
+	// including the ignore rules of the included repositories
+	filter, err := ignoreFilter(tmpGit, repository)
 	if err != nil {
 		err = fmt.Errorf(".sourceignore error: %w", err)
 		return sourcev1.GitRepositoryNotReady(repository, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
-	if repository.Spec.Ignore != nil {
-		ps = append(ps, sourceignore.ReadPatterns(strings.NewReader(*repository.Spec.Ignore), ignoreDomain)...)
-	}
-	if err := r.Storage.Archive(&artifact, tmpGit, SourceIgnoreFilter(ps, ignoreDomain)); err != nil {
+	limits := r.Storage.ArtifactLimitsFor(repository.Spec.ArtifactLimits)
+	if err := r.Storage.WithOrigin(repository.Spec.URL).ArchiveWithLimits(&artifact, tmpGit, filter, limits); err != nil {
+		reason := archiveErrorReason(err)
 		err = fmt.Errorf("storage archive error: %w", err)
-		return sourcev1.GitRepositoryNotReady(repository, sourcev1.StorageOperationFailedReason, err.Error()), err
+		return sourcev1.GitRepositoryNotReady(repository, reason, err.Error()), err
 	}
 
 	// update latest symlink
-	url, err := r.Storage.Symlink(artifact, "latest.tar.gz")
+	linkName, _ := ArchiveFileName(repository.Spec.ArtifactFormat, "latest")
+	url, err := r.Storage.Symlink(artifact, linkName)
 	if err != nil {
 		err = fmt.Errorf("storage symlink error: %w", err)
 		return sourcev1.GitRepositoryNotReady(repository, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
 
 	message := fmt.Sprintf("Fetched revision: %s", artifact.Revision)
+	repository.Status.RetainedArtifacts = r.Storage.RetainArtifacts(repository.Spec.ArtifactRetention, artifact, repository.GetArtifact(),
+		repository.Status.RetainedArtifacts)
 	return sourcev1.GitRepositoryReady(repository, artifact, includedArtifacts, url, sourcev1.GitOperationSucceedReason, message), nil
 }
 
+// ignoreFilter returns the ArchiveFileFilter for the given checkout directory of the given v1beta1.GitRepository,
+// which matches the files excluded by the .sourceignore files in the directory and by the spec of the repository.
+func ignoreFilter(dir string, repository sourcev1.GitRepository) (ArchiveFileFilter, error) {
+	ignoreDomain := strings.Split(dir, string(filepath.Separator))
+	ps, err := sourceignore.LoadIgnorePatterns(dir, ignoreDomain)
+	if err != nil {
+		return nil, err
+	}
+	if repository.Spec.Ignore != nil {
+		ps = append(ps, sourceignore.ReadPatterns(strings.NewReader(*repository.Spec.Ignore), ignoreDomain)...)
+	}
+	return SourceIgnoreFilter(ps, ignoreDomain), nil
+}
+
 func (r *GitRepositoryReconciler) reconcileDelete(ctx context.Context, repository sourcev1.GitRepository) (ctrl.Result, error) {
 	if err := r.gc(repository); err != nil {
 		r.event(ctx, repository, events.EventSeverityError,
@@ -408,7 +431,7 @@ func (r *GitRepositoryReconciler) resetStatus(repository sourcev1.GitRepository)
 }
 
 // gc performs a garbage collection for the given v1beta1.GitRepository.
-// It removes all but the current artifact except for when the
+// It removes all but the current and retained artifacts except for when the
 // deletion timestamp is set, which will result in the removal of
 // all artifacts for the resource.
 func (r *GitRepositoryReconciler) gc(repository sourcev1.GitRepository) error {
@@ -416,7 +439,7 @@ func (r *GitRepositoryReconciler) gc(repository sourcev1.GitRepository) error {
 		return r.Storage.RemoveAll(r.Storage.NewArtifactFor(repository.Kind, repository.GetObjectMeta(), "", "*"))
 	}
 	if repository.GetArtifact() != nil {
-		return r.Storage.RemoveAllButCurrent(*repository.GetArtifact())
+		return r.Storage.RemoveAllButCurrent(*repository.GetArtifact(), repository.Status.RetainedArtifacts...)
 	}
 	return nil
 }
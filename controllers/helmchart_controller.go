@@ -51,7 +51,6 @@ import (
 	"github.com/fluxcd/pkg/runtime/events"
 	"github.com/fluxcd/pkg/runtime/metrics"
 	"github.com/fluxcd/pkg/runtime/predicates"
-	"github.com/fluxcd/pkg/untar"
 
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 	"github.com/fluxcd/source-controller/internal/helm/chart"
@@ -172,7 +171,12 @@ func (r *HelmChartReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		chart.Status.SetLastHandledReconcileRequest(v)
 	}
 
-	// Purge all but current artifact from storage
+	// Apply the artifact retention policy, and purge all but current and
+	// retained artifacts from storage
+	if artifact := chart.GetArtifact(); artifact != nil {
+		chart.Status.RetainedArtifacts = r.Storage.RetainArtifacts(chart.Spec.ArtifactRetention, *artifact, nil,
+			chart.Status.RetainedArtifacts)
+	}
 	if err := r.gc(chart); err != nil {
 		log.Error(err, "unable to purge old artifacts")
 	}
@@ -254,7 +258,7 @@ func (r *HelmChartReconciler) Reconcile(ctx context.Context, req ctrl.Request) (
 		time.Since(start).String(),
 		chart.GetInterval().Duration.String(),
 	))
-	return ctrl.Result{RequeueAfter: chart.GetInterval().Duration}, nil
+	return ctrl.Result{RequeueAfter: r.Storage.RequeueAfter(chart.GetInterval().Duration)}, nil
 }
 
 type HelmChartReconcilerOptions struct {
@@ -383,7 +387,7 @@ func (r *HelmChartReconciler) fromHelmRepository(ctx context.Context, repo sourc
 	defer unlock()
 
 	// Copy the packaged chart to the artifact path
-	if err = r.Storage.CopyFromPath(&newArtifact, b.Path); err != nil {
+	if err = r.Storage.WithOrigin(repo.Spec.URL).CopyFromPath(&newArtifact, b.Path); err != nil {
 		err = fmt.Errorf("failed to write chart package to storage: %w", err)
 		return sourcev1.HelmChartNotReady(c, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
@@ -394,6 +398,8 @@ func (r *HelmChartReconciler) fromHelmRepository(ctx context.Context, repo sourc
 		err = fmt.Errorf("storage error: %w", err)
 		return sourcev1.HelmChartNotReady(c, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
+	c.Status.RetainedArtifacts = r.Storage.RetainArtifacts(c.Spec.ArtifactRetention, newArtifact, c.GetArtifact(),
+		c.Status.RetainedArtifacts)
 	return sourcev1.HelmChartReady(c, newArtifact, cUrl, sourcev1.ChartPullSucceededReason, b.Summary()), nil
 }
 
@@ -406,21 +412,11 @@ func (r *HelmChartReconciler) fromTarballArtifact(ctx context.Context, source so
 		return sourcev1.HelmChartNotReady(c, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
 
-	// Open the tarball artifact file and untar files into working directory
-	f, err := os.Open(r.Storage.LocalPath(source))
-	if err != nil {
-		err = fmt.Errorf("artifact open error: %w", err)
-		return sourcev1.HelmChartNotReady(c, sourcev1.StorageOperationFailedReason, err.Error()), err
-	}
-	if _, err = untar.Untar(f, sourceDir); err != nil {
-		_ = f.Close()
+	// Extract the artifact, in the format of its content type, into working directory
+	if err := r.Storage.Extract(&source, sourceDir); err != nil {
 		err = fmt.Errorf("artifact untar error: %w", err)
 		return sourcev1.HelmChartNotReady(c, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
-	if err = f.Close(); err != nil {
-		err = fmt.Errorf("artifact close error: %w", err)
-		return sourcev1.HelmChartNotReady(c, sourcev1.StorageOperationFailedReason, err.Error()), err
-	}
 
 	chartPath, err := securejoin.SecureJoin(sourceDir, c.Spec.Chart)
 	if err != nil {
@@ -518,7 +514,7 @@ func (r *HelmChartReconciler) fromTarballArtifact(ctx context.Context, source so
 	defer unlock()
 
 	// Copy the packaged chart to the artifact path
-	if err = r.Storage.CopyFromPath(&newArtifact, b.Path); err != nil {
+	if err = r.Storage.WithOrigin(source.URL).CopyFromPath(&newArtifact, b.Path); err != nil {
 		err = fmt.Errorf("failed to write chart package to storage: %w", err)
 		return sourcev1.HelmChartNotReady(c, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
@@ -530,6 +526,8 @@ func (r *HelmChartReconciler) fromTarballArtifact(ctx context.Context, source so
 		return sourcev1.HelmChartNotReady(c, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
 
+	c.Status.RetainedArtifacts = r.Storage.RetainArtifacts(c.Spec.ArtifactRetention, newArtifact, c.GetArtifact(),
+		c.Status.RetainedArtifacts)
 	return sourcev1.HelmChartReady(c, newArtifact, cUrl, reasonForBuildSuccess(b), b.Summary()), nil
 }
 
@@ -618,7 +616,7 @@ func (r *HelmChartReconciler) resetStatus(chart sourcev1.HelmChart) (sourcev1.He
 }
 
 // gc performs a garbage collection for the given v1beta1.HelmChart.
-// It removes all but the current artifact except for when the
+// It removes all but the current and retained artifacts except for when the
 // deletion timestamp is set, which will result in the removal of
 // all artifacts for the resource.
 func (r *HelmChartReconciler) gc(chart sourcev1.HelmChart) error {
@@ -626,7 +624,7 @@ func (r *HelmChartReconciler) gc(chart sourcev1.HelmChart) error {
 		return r.Storage.RemoveAll(r.Storage.NewArtifactFor(chart.Kind, chart.GetObjectMeta(), "", "*"))
 	}
 	if chart.GetArtifact() != nil {
-		return r.Storage.RemoveAllButCurrent(*chart.GetArtifact())
+		return r.Storage.RemoveAllButCurrent(*chart.GetArtifact(), chart.Status.RetainedArtifacts...)
 	}
 	return nil
 }
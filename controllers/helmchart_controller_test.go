@@ -25,6 +25,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"testing"
 	"time"
 
 	"github.com/fluxcd/pkg/apis/meta"
@@ -43,7 +44,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	apimeta "k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/yaml"
 
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
@@ -1335,3 +1338,66 @@ var _ = Describe("HelmChartReconciler", func() {
 		})
 	})
 })
+
+func TestHelmChartReconciler_fromTarballArtifact(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := sourcev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := createStoragePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanupStoragePath(dir))
+	storage, err := NewStorage(dir, "hostname", time.Minute)
+	if err != nil {
+		t.Fatalf("error while bootstrapping storage: %v", err)
+	}
+	r := &HelmChartReconciler{
+		Client:  fake.NewClientBuilder().WithScheme(scheme).Build(),
+		Storage: storage,
+	}
+	charts, err := filepath.Abs("testdata/charts")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{sourcev1.ArtifactFormatGzip, sourcev1.ArtifactFormatZstd, sourcev1.ArtifactFormatOCI} {
+		t.Run(format, func(t *testing.T) {
+			fileName, contentType := ArchiveFileName(format, "revision")
+			source := sourcev1.Artifact{
+				Path:        path.Join(sourcev1.GitRepositoryKind, "default", "charts-"+format, fileName),
+				Revision:    "main/0123456789abcdef0123456789abcdef01234567",
+				ContentType: contentType,
+			}
+			if err := storage.MkdirAll(source); err != nil {
+				t.Fatalf("artifact directory creation failed: %v", err)
+			}
+			if err := storage.Archive(&source, charts, nil); err != nil {
+				t.Fatalf("Archive() error = %v", err)
+			}
+
+			chart := sourcev1.HelmChart{
+				TypeMeta:   metav1.TypeMeta{Kind: sourcev1.HelmChartKind},
+				ObjectMeta: metav1.ObjectMeta{Name: "helmchart-" + format, Namespace: "default"},
+				Spec: sourcev1.HelmChartSpec{
+					Chart: "helmchart",
+					SourceRef: sourcev1.LocalHelmChartSourceReference{
+						Kind: sourcev1.GitRepositoryKind,
+						Name: "charts-" + format,
+					},
+				},
+			}
+			got, err := r.fromTarballArtifact(context.TODO(), source, chart, t.TempDir(), false)
+			if err != nil {
+				t.Fatalf("fromTarballArtifact() error = %v", err)
+			}
+			if got.Status.Artifact == nil || !storage.ArtifactExist(*got.Status.Artifact) {
+				t.Fatalf("fromTarballArtifact() artifact = %v, want chart package in storage", got.Status.Artifact)
+			}
+			if want := "helmchart-0.1.0.tgz"; path.Base(got.Status.Artifact.Path) != want {
+				t.Errorf("fromTarballArtifact() artifact path = %s, want %s", got.Status.Artifact.Path, want)
+			}
+		})
+	}
+}
@@ -138,7 +138,11 @@ func (r *HelmRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		repository.Status.SetLastHandledReconcileRequest(v)
 	}
 
-	// purge old artifacts from storage
+	// apply the artifact retention policy and purge old artifacts from storage
+	if artifact := repository.GetArtifact(); artifact != nil {
+		repository.Status.RetainedArtifacts = r.Storage.RetainArtifacts(repository.Spec.ArtifactRetention, *artifact, nil,
+			repository.Status.RetainedArtifacts)
+	}
 	if err := r.gc(repository); err != nil {
 		log.Error(err, "unable to purge old artifacts")
 	}
@@ -170,7 +174,7 @@ func (r *HelmRepositoryReconciler) Reconcile(ctx context.Context, req ctrl.Reque
 		repository.GetInterval().Duration.String(),
 	))
 
-	return ctrl.Result{RequeueAfter: repository.GetInterval().Duration}, nil
+	return ctrl.Result{RequeueAfter: r.Storage.RequeueAfter(repository.GetInterval().Duration)}, nil
 }
 
 func (r *HelmRepositoryReconciler) reconcile(ctx context.Context, repo sourcev1.HelmRepository) (sourcev1.HelmRepository, error) {
@@ -267,7 +271,7 @@ func (r *HelmRepositoryReconciler) reconcile(ctx context.Context, repo sourcev1.
 	defer unlock()
 
 	// Save artifact to storage
-	if err = r.Storage.CopyFromPath(&artifact, chartRepo.CachePath); err != nil {
+	if err = r.Storage.WithOrigin(repo.Spec.URL).CopyFromPath(&artifact, chartRepo.CachePath); err != nil {
 		return sourcev1.HelmRepositoryNotReady(repo, sourcev1.StorageOperationFailedReason, err.Error()), err
 	}
 
@@ -279,6 +283,8 @@ func (r *HelmRepositoryReconciler) reconcile(ctx context.Context, repo sourcev1.
 	}
 
 	message := fmt.Sprintf("Fetched revision: %s", artifact.Revision)
+	repo.Status.RetainedArtifacts = r.Storage.RetainArtifacts(repo.Spec.ArtifactRetention, artifact, repo.GetArtifact(),
+		repo.Status.RetainedArtifacts)
 	return sourcev1.HelmRepositoryReady(repo, artifact, indexURL, sourcev1.IndexationSucceededReason, message), nil
 }
 
@@ -320,7 +326,7 @@ func (r *HelmRepositoryReconciler) resetStatus(repository sourcev1.HelmRepositor
 }
 
 // gc performs a garbage collection for the given v1beta1.HelmRepository.
-// It removes all but the current artifact except for when the
+// It removes all but the current and retained artifacts except for when the
 // deletion timestamp is set, which will result in the removal of
 // all artifacts for the resource.
 func (r *HelmRepositoryReconciler) gc(repository sourcev1.HelmRepository) error {
@@ -328,7 +334,7 @@ func (r *HelmRepositoryReconciler) gc(repository sourcev1.HelmRepository) error
 		return r.Storage.RemoveAll(r.Storage.NewArtifactFor(repository.Kind, repository.GetObjectMeta(), "", "*"))
 	}
 	if repository.GetArtifact() != nil {
-		return r.Storage.RemoveAllButCurrent(*repository.GetArtifact())
+		return r.Storage.RemoveAllButCurrent(*repository.GetArtifact(), repository.Status.RetainedArtifacts...)
 	}
 	return nil
 }
@@ -20,24 +20,27 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"hash"
 	"io"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
 	securejoin "github.com/cyphar/filepath-securejoin"
 	"github.com/go-git/go-git/v5/plumbing/format/gitignore"
+	"github.com/klauspost/compress/zstd"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/fluxcd/pkg/lockedfile"
-
-	"github.com/fluxcd/pkg/untar"
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/fluxcd/source-controller/internal/fileserver"
 	"github.com/fluxcd/source-controller/internal/fs"
+	"github.com/fluxcd/source-controller/internal/signing"
 	"github.com/fluxcd/source-controller/pkg/sourceignore"
 )
 
@@ -49,8 +52,51 @@ type Storage struct {
 	// Hostname is the file server host name used to compose the artifacts URIs.
 	Hostname string `json:"hostname"`
 
+	// Scheme is the file server URL scheme used to compose the artifacts URIs, defaults to http.
+	Scheme string `json:"scheme,omitempty"`
+
+	// URLSigner is used to sign the artifacts URIs when the file server requires signed URLs.
+	URLSigner *fileserver.URLSigner `json:"-"`
+
+	// Signer is used to write a detached signature next to every artifact, if set.
+	Signer *signing.Signer `json:"-"`
+
 	// Timeout for artifacts operations
 	Timeout time.Duration `json:"timeout"`
+
+	// DeterministicArchive enables the archiving of directories as byte-identical tarballs for identical trees,
+	// preserving in-tree symbolic links and the executable bit of files.
+	DeterministicArchive bool `json:"deterministicArchive"`
+
+	// ArtifactRetention is the retention policy for previous artifacts of
+	// sources that do not define their own.
+	ArtifactRetention sourcev1.ArtifactRetention `json:"artifactRetention"`
+
+	// ArtifactLimits are the limits of the files archived by Archive, and the
+	// upper bound of the limits of sources that define their own.
+	ArtifactLimits sourcev1.ArtifactLimits `json:"artifactLimits"`
+
+	// Quota is the maximum number of bytes the files in storage may use, unlimited if zero.
+	// When a new artifact would exceed the quota, the least recently served artifacts that are not in use are evicted.
+	Quota int64 `json:"quota,omitempty"`
+
+	// InUse lists the artifacts in use by the sources, which are never evicted to stay within the Quota. It must be
+	// set when a Quota is set.
+	InUse ArtifactLister `json:"-"`
+
+	// Metrics records the storage usage, if set.
+	Metrics *StorageMetricsRecorder `json:"-"`
+
+	// ControllerVersion is the version of the controller recorded in the metadata of the artifacts.
+	ControllerVersion string `json:"controllerVersion,omitempty"`
+
+	// EmbedMetadata enables the embedding of the metadata of archived artifacts at the EmbeddedMetadataPath.
+	EmbedMetadata bool `json:"embedMetadata,omitempty"`
+
+	usage *storageUsage
+
+	// origin is the URL recorded as the origin in the metadata of the artifacts, see WithOrigin.
+	origin string
 }
 
 // NewStorage creates the storage helper for a given path and hostname
@@ -62,6 +108,7 @@ func NewStorage(basePath string, hostname string, timeout time.Duration) (*Stora
 		BasePath: basePath,
 		Hostname: hostname,
 		Timeout:  timeout,
+		usage:    newStorageUsage(),
 	}, nil
 }
 
@@ -81,19 +128,62 @@ func (s Storage) SetArtifactURL(artifact *sourcev1.Artifact) {
 	if artifact.Path == "" {
 		return
 	}
-	artifact.URL = fmt.Sprintf("http://%s/%s", s.Hostname, artifact.Path)
+	artifact.URL = s.fileURL(artifact.Path)
+	if artifact.Signature != nil {
+		artifact.Signature.URL = s.fileURL(artifact.Signature.Path)
+	}
+	if artifact.Manifest != nil {
+		artifact.Manifest.URL = s.fileURL(artifact.Manifest.Path)
+	}
+	if artifact.Metadata != nil {
+		artifact.Metadata.URL = s.fileURL(artifact.Metadata.Path)
+	}
 }
 
-// SetHostname sets the hostname of the given URL string to the current Storage.Hostname and returns the result.
+// SetHostname sets the scheme and hostname of the given URL string to the current Storage.Scheme and
+// Storage.Hostname and returns the result. When the Storage.URLSigner is set, the URL is signed again.
 func (s Storage) SetHostname(URL string) string {
 	u, err := url.Parse(URL)
 	if err != nil {
 		return ""
 	}
+	u.Scheme = s.scheme()
 	u.Host = s.Hostname
+	if s.URLSigner != nil {
+		s.URLSigner.Sign(u)
+	}
+	return u.String()
+}
+
+// fileURL returns the file server URL for the given path relative to the Storage.BasePath, signed with the
+// Storage.URLSigner if set.
+func (s Storage) fileURL(path string) string {
+	scheme := s.scheme()
+	if s.URLSigner == nil {
+		return fmt.Sprintf("%s://%s/%s", scheme, s.Hostname, path)
+	}
+	u := &url.URL{Scheme: scheme, Host: s.Hostname, Path: "/" + path}
+	s.URLSigner.Sign(u)
 	return u.String()
 }
 
+// RequeueAfter returns the given reconcile interval, capped to the TTL of the Storage.URLSigner if set. As the URLs
+// in the status of a source are only signed again on reconciliation, this ensures they are renewed before they expire.
+func (s Storage) RequeueAfter(interval time.Duration) time.Duration {
+	if s.URLSigner != nil && s.URLSigner.TTL() < interval {
+		return s.URLSigner.TTL()
+	}
+	return interval
+}
+
+// scheme returns the Storage.Scheme, or http if not set.
+func (s Storage) scheme() string {
+	if s.Scheme == "" {
+		return "http"
+	}
+	return s.Scheme
+}
+
 // MkdirAll calls os.MkdirAll for the given v1beta1.Artifact base dir.
 func (s *Storage) MkdirAll(artifact sourcev1.Artifact) error {
 	dir := filepath.Dir(s.LocalPath(artifact))
@@ -103,13 +193,22 @@ func (s *Storage) MkdirAll(artifact sourcev1.Artifact) error {
 // RemoveAll calls os.RemoveAll for the given v1beta1.Artifact base dir.
 func (s *Storage) RemoveAll(artifact sourcev1.Artifact) error {
 	dir := filepath.Dir(s.LocalPath(artifact))
+	defer s.updateUsage(dir)
 	return os.RemoveAll(dir)
 }
 
-// RemoveAllButCurrent removes all files for the given v1beta1.Artifact base dir, excluding the current one.
-func (s *Storage) RemoveAllButCurrent(artifact sourcev1.Artifact) error {
+// RemoveAllButCurrent removes all files for the given v1beta1.Artifact base dir, excluding the current one and any
+// of the given retained artifacts, and their signature, manifest and metadata sidecars.
+func (s *Storage) RemoveAllButCurrent(artifact sourcev1.Artifact, retained ...sourcev1.Artifact) error {
 	localPath := s.LocalPath(artifact)
 	dir := filepath.Dir(localPath)
+	keep := map[string]struct{}{}
+	for _, a := range append([]sourcev1.Artifact{artifact}, retained...) {
+		keep[s.LocalPath(a)] = struct{}{}
+		for _, ext := range sidecarExtensions {
+			keep[s.LocalPath(a)+ext] = struct{}{}
+		}
+	}
 	var errors []string
 	_ = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
@@ -117,10 +216,11 @@ func (s *Storage) RemoveAllButCurrent(artifact sourcev1.Artifact) error {
 			return nil
 		}
 
-		if path != localPath && !info.IsDir() && info.Mode()&os.ModeSymlink != os.ModeSymlink {
+		if _, ok := keep[path]; !ok && !info.IsDir() && info.Mode()&os.ModeSymlink != os.ModeSymlink {
 			if err := os.Remove(path); err != nil {
 				errors = append(errors, info.Name())
 			}
+			s.updateUsage(path)
 		}
 		return nil
 	})
@@ -131,13 +231,63 @@ func (s *Storage) RemoveAllButCurrent(artifact sourcev1.Artifact) error {
 	return nil
 }
 
-// ArtifactExist returns a boolean indicating whether the v1beta1.Artifact exists in storage and is a regular file.
+// RetainArtifacts returns the previous artifacts to keep in storage next to the given current v1beta1.Artifact,
+// according to the given v1beta1.ArtifactRetention, or Storage.ArtifactRetention if nil.
+// The previous artifact, if given and not equal to the current one, is prepended to the retained artifacts. An
+// artifact is kept if it is within the number of records of the policy, or if it has been superseded for less than
+// the minimum age. Artifacts that no longer exist in storage are dropped, and the URLs of the others are updated.
+func (s *Storage) RetainArtifacts(policy *sourcev1.ArtifactRetention, current sourcev1.Artifact, previous *sourcev1.Artifact,
+	retained []sourcev1.Artifact) []sourcev1.Artifact {
+	if policy == nil {
+		policy = &s.ArtifactRetention
+	}
+	var minAge time.Duration
+	if policy.MinAge != nil {
+		minAge = policy.MinAge.Duration
+	}
+
+	candidates := retained
+	if previous != nil {
+		candidates = append([]sourcev1.Artifact{*previous}, retained...)
+	}
+
+	// An artifact has been superseded at the time its successor was last
+	// updated, the candidates are ordered newest first.
+	supersededAt := current.LastUpdateTime.Time
+	if supersededAt.IsZero() {
+		supersededAt = time.Now()
+	}
+	seen := map[string]struct{}{current.Path: {}}
+	var result []sourcev1.Artifact
+	for _, a := range candidates {
+		age := time.Since(supersededAt)
+		supersededAt = a.LastUpdateTime.Time
+		if _, ok := seen[a.Path]; ok {
+			continue
+		}
+		seen[a.Path] = struct{}{}
+		if !s.ArtifactExist(a) {
+			continue
+		}
+		if len(result) >= policy.Records && age >= minAge {
+			continue
+		}
+		// the candidates share their sidecar pointers with the caller
+		retain := a.DeepCopy()
+		s.SetArtifactURL(retain)
+		result = append(result, *retain)
+	}
+	return result
+}
+
+// ArtifactExist returns a boolean indicating whether the v1beta1.Artifact exists in storage and is a regular file
+// that has not been marked as corrupted, see MarkCorrupted.
 func (s *Storage) ArtifactExist(artifact sourcev1.Artifact) bool {
 	fi, err := os.Lstat(s.LocalPath(artifact))
 	if err != nil {
 		return false
 	}
-	return fi.Mode().IsRegular()
+	return fi.Mode().IsRegular() && !s.isCorrupted(artifact.Path, fi)
 }
 
 // ArchiveFileFilter must return true if a file should not be included in the archive after inspecting the given path
@@ -158,21 +308,97 @@ func SourceIgnoreFilter(ps []gitignore.Pattern, domain []string) ArchiveFileFilt
 	}
 }
 
+// sign atomically writes the detached signature of the given v1beta1.Artifact next to it using the Storage.Signer,
+// and sets the signature reference on the artifact. If the Storage.Signer is not set, the signature reference is
+// removed from the artifact.
+func (s *Storage) sign(artifact *sourcev1.Artifact) error {
+	if s.Signer == nil {
+		artifact.Signature = nil
+		return nil
+	}
+
+	digest, err := hex.DecodeString(artifact.Checksum)
+	if err != nil {
+		return fmt.Errorf("invalid artifact checksum: %w", err)
+	}
+	sig, err := s.Signer.Sign(digest)
+	if err != nil {
+		return fmt.Errorf("failed to sign artifact: %w", err)
+	}
+
+	sigPath := artifact.Path + signing.SignatureExtension
+	if err := s.writeSidecar(s.LocalPath(sourcev1.Artifact{Path: sigPath}), sig); err != nil {
+		return err
+	}
+
+	artifact.Signature = &sourcev1.ArtifactSignature{
+		Path:  sigPath,
+		URL:   s.fileURL(sigPath),
+		KeyID: s.Signer.KeyID(),
+	}
+	return nil
+}
+
+// writeSidecar atomically writes the given data to the given local path of a file next to an artifact.
+func (s *Storage) writeSidecar(localPath string, data []byte) (err error) {
+	tf, err := s.createTemp(localPath)
+	if err != nil {
+		return err
+	}
+	tfName := tf.Name()
+	defer s.releaseTemp(tfName)
+	defer func() {
+		if err != nil {
+			os.Remove(tfName)
+		}
+	}()
+	if _, err := tf.Write(data); err != nil {
+		tf.Close()
+		return err
+	}
+	if err := tf.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tfName, 0644); err != nil {
+		return err
+	}
+	if err := fs.RenameWithFallback(tfName, localPath); err != nil {
+		return err
+	}
+	s.updateUsage(localPath)
+	return nil
+}
+
 // Archive atomically archives the given directory as a tarball to the given v1beta1.Artifact path, excluding
 // directories and any ArchiveFileFilter matches. While archiving, any environment specific data (for example,
 // the user and group name) is stripped from file headers.
-// If successful, it sets the checksum and last update time on the artifact.
-func (s *Storage) Archive(artifact *sourcev1.Artifact, dir string, filter ArchiveFileFilter) (err error) {
+// When Storage.DeterministicArchive is set, the entries are written by writeDeterministicEntry.
+// The tarball is written in the format of the content type of the artifact, which defaults to a gzip compressed
+// tarball (v1beta1.ArtifactContentTypeGzip).
+// The files are limited by the Storage.ArtifactLimits, see ArchiveWithLimits.
+// The artifact is only written if it fits in the Storage.Quota, see reserve.
+// If successful, it sets the content type, checksum and last update time on the artifact, writes the FileManifest of
+// the archived files next to it, and signs it.
+func (s *Storage) Archive(artifact *sourcev1.Artifact, dir string, filter ArchiveFileFilter) error {
+	return s.ArchiveWithLimits(artifact, dir, filter, s.ArtifactLimits)
+}
+
+// ArchiveWithLimits archives the given directory like Archive, but limits the files that are not matched by the
+// ArchiveFileFilter with the given v1beta1.ArtifactLimits. If a limit is exceeded, no artifact is written and an
+// ArtifactLimitError is returned.
+func (s *Storage) ArchiveWithLimits(artifact *sourcev1.Artifact, dir string, filter ArchiveFileFilter,
+	limits sourcev1.ArtifactLimits) (err error) {
 	if f, err := os.Stat(dir); os.IsNotExist(err) || !f.IsDir() {
 		return fmt.Errorf("invalid dir path: %s", dir)
 	}
 
 	localPath := s.LocalPath(*artifact)
-	tf, err := os.CreateTemp(filepath.Split(localPath))
+	tf, err := s.createTemp(localPath)
 	if err != nil {
 		return err
 	}
 	tmpName := tf.Name()
+	defer s.releaseTemp(tmpName)
 	defer func() {
 		if err != nil {
 			os.Remove(tmpName)
@@ -182,13 +408,225 @@ func (s *Storage) Archive(artifact *sourcev1.Artifact, dir string, filter Archiv
 	h := newHash()
 	mw := io.MultiWriter(h, tf)
 
-	gw := gzip.NewWriter(mw)
-	tw := tar.NewWriter(gw)
+	contentType := artifact.ContentType
+	if contentType == "" {
+		contentType = sourcev1.ArtifactContentTypeGzip
+	}
+	embedded, err := s.embeddedMetadata(*artifact)
+	if err != nil {
+		return err
+	}
+	if embedded != nil {
+		filter = excludeEmbeddedMetadata(dir, filter)
+	}
+	var limitErr error
+	filter = NewArtifactLimiter(limits).limitFilter(dir, filter, &limitErr)
+	if err := s.writeArchive(mw, contentType, artifact.Revision, dir, filter, embedded...); err != nil {
+		tf.Close()
+		return err
+	}
+	if err := tf.Close(); err != nil {
+		return err
+	}
+	if limitErr != nil {
+		return limitErr
+	}
+
+	if err := os.Chmod(tmpName, 0644); err != nil {
+		return err
+	}
+
+	mf, err := os.Open(tmpName)
+	if err != nil {
+		return err
+	}
+	manifest, err := buildManifest(contentType, artifact.Revision, mf)
+	mf.Close()
+	if err != nil {
+		return fmt.Errorf("failed to build manifest: %w", err)
+	}
+
+	if err := s.reserve(tmpName, localPath); err != nil {
+		return err
+	}
+
+	if err := fs.RenameWithFallback(tmpName, localPath); err != nil {
+		return err
+	}
+	s.updateUsage(localPath)
+
+	artifact.ContentType = contentType
+	artifact.Checksum = fmt.Sprintf("%x", h.Sum(nil))
+	artifact.LastUpdateTime = metav1.Now()
+	if err := s.writeManifest(artifact, manifest); err != nil {
+		return err
+	}
+	if err := s.writeMetadata(artifact); err != nil {
+		return err
+	}
+	return s.sign(artifact)
+}
+
+// archiveFormats maps the v1beta1 artifact formats to their content type and file name extension.
+var archiveFormats = map[string]struct {
+	contentType string
+	extension   string
+}{
+	sourcev1.ArtifactFormatGzip: {sourcev1.ArtifactContentTypeGzip, ".tar.gz"},
+	sourcev1.ArtifactFormatZstd: {sourcev1.ArtifactContentTypeZstd, ".tar.zst"},
+	sourcev1.ArtifactFormatOCI:  {sourcev1.ArtifactContentTypeOCI, ".oci.tar"},
+}
+
+// ArchiveFileName returns the file name for an archive with the given name in the given v1beta1 artifact format, and
+// the content type of the format. The format defaults to v1beta1.ArtifactFormatGzip.
+func ArchiveFileName(format, name string) (fileName string, contentType string) {
+	f, ok := archiveFormats[format]
+	if !ok {
+		f = archiveFormats[sourcev1.ArtifactFormatGzip]
+	}
+	return name + f.extension, f.contentType
+}
+
+// writeArchive writes the archive of the given directory in the format of the given content type to the io.Writer,
+// followed by the given extra entries.
+func (s *Storage) writeArchive(w io.Writer, contentType, revision, dir string, filter ArchiveFileFilter,
+	extra ...tarEntry) error {
+	switch contentType {
+	case sourcev1.ArtifactContentTypeGzip:
+		return s.writeGzipTar(w, dir, filter, extra...)
+	case sourcev1.ArtifactContentTypeZstd:
+		// A single encoder goroutine ensures the output does not depend on the number of CPUs of the host.
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		if err := s.writeTar(zw, dir, filter, extra...); err != nil {
+			zw.Close()
+			return err
+		}
+		return zw.Close()
+	case sourcev1.ArtifactContentTypeOCI:
+		return s.writeOCILayout(w, revision, dir, filter, extra...)
+	default:
+		return fmt.Errorf("unsupported artifact content type '%s'", contentType)
+	}
+}
+
+// writeGzipTar writes the gzip compressed tarball of the given directory to the io.Writer.
+func (s *Storage) writeGzipTar(w io.Writer, dir string, filter ArchiveFileFilter, extra ...tarEntry) error {
+	gw := gzip.NewWriter(w)
+	if err := s.writeTar(gw, dir, filter, extra...); err != nil {
+		gw.Close()
+		return err
+	}
+	return gw.Close()
+}
+
+// tarReader returns a reader of the uncompressed tarball in the archive in the format of the given content type in
+// the given file. The reader must be closed to release the resources of the decompressor.
+func tarReader(contentType string, f *os.File) (io.ReadCloser, error) {
+	switch contentType {
+	case "", sourcev1.ArtifactContentTypeGzip:
+		return gzip.NewReader(f)
+	case sourcev1.ArtifactContentTypeZstd:
+		zr, err := zstd.NewReader(f, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case sourcev1.ArtifactContentTypeOCI:
+		lr, err := ociContentReader(f)
+		if err != nil {
+			return nil, err
+		}
+		return gzip.NewReader(lr)
+	default:
+		return nil, fmt.Errorf("unsupported artifact content type '%s'", contentType)
+	}
+}
+
+// extractTar writes the directories, regular files and symbolic links of the tarball from the given io.Reader to the
+// given directory. Entries of which the name, or the target of a symbolic link, is outside the directory are
+// rejected, other file types are skipped.
+func extractTar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(filepath.ToSlash(header.Name))
+		if !localSlashPath(name) {
+			return fmt.Errorf("tarball entry '%s' is outside of the directory", header.Name)
+		}
+		// Resolve the path within the directory, in case of symbolic links in the parent directories.
+		p, err := securejoin.SecureJoin(dir, name)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(p, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(f, tr); err != nil {
+				f.Close()
+				return err
+			}
+			if err := f.Close(); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if path.IsAbs(header.Linkname) || !localSlashPath(path.Join(path.Dir(name), header.Linkname)) {
+				return fmt.Errorf("tarball entry '%s' links to '%s' outside of the directory", header.Name,
+					header.Linkname)
+			}
+			if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(filepath.FromSlash(header.Linkname), p); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// localSlashPath returns true if the given cleaned slash separated path is relative, and does not start with "..".
+func localSlashPath(p string) bool {
+	return !path.IsAbs(p) && p != ".." && !strings.HasPrefix(p, "../")
+}
+
+// tarEntry is a regular file written to a tarball after the files of the archived directory.
+type tarEntry struct {
+	name string
+	data []byte
+}
+
+// writeTar writes the tarball of the given directory to the io.Writer, excluding any ArchiveFileFilter matches,
+// followed by the given extra entries.
+func (s *Storage) writeTar(w io.Writer, dir string, filter ArchiveFileFilter, extra ...tarEntry) error {
+	tw := tar.NewWriter(w)
 	if err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
 
+		if s.DeterministicArchive {
+			return writeDeterministicEntry(tw, dir, p, fi, filter)
+		}
+
 		// Ignore anything that is not a file or directories e.g. symlinks
 		if m := fi.Mode(); !(m.IsRegular() || m.IsDir()) {
 			return nil
@@ -244,46 +682,137 @@ func (s *Storage) Archive(artifact *sourcev1.Artifact, dir string, filter Archiv
 		return f.Close()
 	}); err != nil {
 		tw.Close()
-		gw.Close()
-		tf.Close()
 		return err
 	}
+	for _, e := range extra {
+		header := &tar.Header{
+			Name:     e.name,
+			Typeflag: tar.TypeReg,
+			Mode:     0644,
+			Size:     int64(len(e.data)),
+			ModTime:  time.Unix(0, 0),
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			tw.Close()
+			return err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			tw.Close()
+			return err
+		}
+	}
+	return tw.Close()
+}
 
-	if err := tw.Close(); err != nil {
-		gw.Close()
-		tf.Close()
-		return err
+// writeDeterministicEntry writes the tar entry for the file at the given path in the given directory to the
+// tar.Writer, unless it matches the ArchiveFileFilter. The header of the entry is composed from scratch, with the
+// name relative to the directory using forward slashes, a zero modification time, and the mode normalized to 0755
+// for directories and executable files, or 0644 for other files. Symbolic links are preserved if their target is
+// within the directory, and rewritten to be relative to the link. Other symbolic links and file types are skipped.
+// As entries are walked in lexical order, identical directory trees result in byte-identical tarballs regardless
+// of the host.
+func writeDeterministicEntry(tw *tar.Writer, dir, p string, fi os.FileInfo, filter ArchiveFileFilter) error {
+	if filter != nil && filter(p, fi) {
+		return nil
 	}
-	if err := gw.Close(); err != nil {
-		tf.Close()
+
+	relPath, err := filepath.Rel(dir, p)
+	if err != nil {
 		return err
 	}
-	if err := tf.Close(); err != nil {
-		return err
+	if relPath == "." {
+		return nil
 	}
 
-	if err := os.Chmod(tmpName, 0644); err != nil {
-		return err
+	header := &tar.Header{
+		Name:    filepath.ToSlash(relPath),
+		ModTime: time.Unix(0, 0),
+		Format:  tar.FormatPAX,
+	}
+	switch m := fi.Mode(); {
+	case m.IsDir():
+		header.Typeflag = tar.TypeDir
+		header.Name += "/"
+		header.Mode = 0755
+	case m.IsRegular():
+		header.Typeflag = tar.TypeReg
+		header.Size = fi.Size()
+		header.Mode = 0644
+		if m&0111 != 0 {
+			header.Mode = 0755
+		}
+	case m&os.ModeSymlink != 0:
+		target, ok, err := inTreeSymlinkTarget(dir, p)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return nil
+		}
+		header.Typeflag = tar.TypeSymlink
+		header.Linkname = target
+		header.Mode = 0777
+	default:
+		return nil
 	}
 
-	if err := fs.RenameWithFallback(tmpName, localPath); err != nil {
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		f.Close()
 		return err
 	}
+	return f.Close()
+}
 
-	artifact.Checksum = fmt.Sprintf("%x", h.Sum(nil))
-	artifact.LastUpdateTime = metav1.Now()
-	return nil
+// inTreeSymlinkTarget returns the target of the symbolic link at the given path relative to the link, and true if the
+// target is within the given directory. The target is evaluated lexically, and is not required to exist.
+func inTreeSymlinkTarget(dir, p string) (string, bool, error) {
+	target, err := os.Readlink(p)
+	if err != nil {
+		return "", false, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", false, err
+	}
+	absPath, err := filepath.Abs(p)
+	if err != nil {
+		return "", false, err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(absPath), target)
+	}
+	rel, err := filepath.Rel(absDir, filepath.Clean(target))
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false, nil
+	}
+	linkname, err := filepath.Rel(filepath.Dir(absPath), filepath.Join(absDir, rel))
+	if err != nil {
+		return "", false, err
+	}
+	return filepath.ToSlash(linkname), true, nil
 }
 
 // AtomicWriteFile atomically writes the io.Reader contents to the v1beta1.Artifact path.
-// If successful, it sets the checksum and last update time on the artifact.
+// The artifact is only written if it fits in the Storage.Quota, see reserve.
+// If successful, it sets the checksum and last update time on the artifact, and signs it.
 func (s *Storage) AtomicWriteFile(artifact *sourcev1.Artifact, reader io.Reader, mode os.FileMode) (err error) {
 	localPath := s.LocalPath(*artifact)
-	tf, err := os.CreateTemp(filepath.Split(localPath))
+	tf, err := s.createTemp(localPath)
 	if err != nil {
 		return err
 	}
 	tfName := tf.Name()
+	defer s.releaseTemp(tfName)
 	defer func() {
 		if err != nil {
 			os.Remove(tfName)
@@ -305,24 +834,34 @@ func (s *Storage) AtomicWriteFile(artifact *sourcev1.Artifact, reader io.Reader,
 		return err
 	}
 
+	if err := s.reserve(tfName, localPath); err != nil {
+		return err
+	}
+
 	if err := fs.RenameWithFallback(tfName, localPath); err != nil {
 		return err
 	}
+	s.updateUsage(localPath)
 
 	artifact.Checksum = fmt.Sprintf("%x", h.Sum(nil))
 	artifact.LastUpdateTime = metav1.Now()
-	return nil
+	if err := s.writeMetadata(artifact); err != nil {
+		return err
+	}
+	return s.sign(artifact)
 }
 
 // Copy atomically copies the io.Reader contents to the v1beta1.Artifact path.
-// If successful, it sets the checksum and last update time on the artifact.
+// The artifact is only written if it fits in the Storage.Quota, see reserve.
+// If successful, it sets the checksum and last update time on the artifact, and signs it.
 func (s *Storage) Copy(artifact *sourcev1.Artifact, reader io.Reader) (err error) {
 	localPath := s.LocalPath(*artifact)
-	tf, err := os.CreateTemp(filepath.Split(localPath))
+	tf, err := s.createTemp(localPath)
 	if err != nil {
 		return err
 	}
 	tfName := tf.Name()
+	defer s.releaseTemp(tfName)
 	defer func() {
 		if err != nil {
 			os.Remove(tfName)
@@ -340,13 +879,21 @@ func (s *Storage) Copy(artifact *sourcev1.Artifact, reader io.Reader) (err error
 		return err
 	}
 
+	if err := s.reserve(tfName, localPath); err != nil {
+		return err
+	}
+
 	if err := fs.RenameWithFallback(tfName, localPath); err != nil {
 		return err
 	}
+	s.updateUsage(localPath)
 
 	artifact.Checksum = fmt.Sprintf("%x", h.Sum(nil))
 	artifact.LastUpdateTime = metav1.Now()
-	return nil
+	if err := s.writeMetadata(artifact); err != nil {
+		return err
+	}
+	return s.sign(artifact)
 }
 
 // CopyFromPath atomically copies the contents of the given path to the path of the v1beta1.Artifact.
@@ -365,6 +912,23 @@ func (s *Storage) CopyFromPath(artifact *sourcev1.Artifact, path string) (err er
 	return err
 }
 
+// Extract writes the contents of the archive of the given artifact, in the format of its content type, to the given
+// directory.
+func (s *Storage) Extract(artifact *sourcev1.Artifact, dir string) error {
+	f, err := os.Open(s.LocalPath(*artifact))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r, err := tarReader(artifact.ContentType, f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return extractTar(r, dir)
+}
+
 // CopyToPath copies the contents in the (sub)path of the given artifact to the given path.
 func (s *Storage) CopyToPath(artifact *sourcev1.Artifact, subPath, toPath string) error {
 	// create a tmp directory to store artifact
@@ -374,17 +938,9 @@ func (s *Storage) CopyToPath(artifact *sourcev1.Artifact, subPath, toPath string
 	}
 	defer os.RemoveAll(tmp)
 
-	// read artifact file content
-	localPath := s.LocalPath(*artifact)
-	f, err := os.Open(localPath)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-
 	// untar the artifact
 	untarPath := filepath.Join(tmp, "unpack")
-	if _, err = untar.Untar(f, untarPath); err != nil {
+	if err := s.Extract(artifact, untarPath); err != nil {
 		return err
 	}
 
@@ -423,8 +979,7 @@ func (s *Storage) Symlink(artifact sourcev1.Artifact, linkName string) (string,
 		return "", err
 	}
 
-	url := fmt.Sprintf("http://%s/%s", s.Hostname, filepath.Join(filepath.Dir(artifact.Path), linkName))
-	return url, nil
+	return s.fileURL(filepath.Join(filepath.Dir(artifact.Path), linkName)), nil
 }
 
 // Checksum returns the SHA256 checksum for the data of the given io.Reader as a string.
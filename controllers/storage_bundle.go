@@ -0,0 +1,374 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/fluxcd/source-controller/internal/fs"
+)
+
+const (
+	// bundleSourcesName is the name of the entry in a bundle holding the BundleSource list.
+	bundleSourcesName = "sources.json"
+	// bundleStoragePrefix is the prefix of the entries in a bundle holding the files in storage.
+	bundleStoragePrefix = "storage/"
+)
+
+// BundleSource is a source of which the artifacts are exported to a bundle.
+type BundleSource struct {
+	// Kind is the kind of the source.
+	Kind string `json:"kind"`
+	// Namespace is the namespace of the source.
+	Namespace string `json:"namespace"`
+	// Name is the name of the source.
+	Name string `json:"name"`
+	// Status is the status of the source at the time of the export.
+	Status map[string]interface{} `json:"status"`
+}
+
+// BundleResult is the result of an export or import of a bundle.
+type BundleResult struct {
+	// Sources is the number of sources of which the artifacts have been exported or imported.
+	Sources int
+	// Files is the number of files that have been exported or imported.
+	Files int
+	// Statuses is the number of source statuses that have been imported.
+	Statuses int
+}
+
+// ExportBundle writes a gzip compressed tarball to the given io.Writer with the artifact directories in storage of
+// all sources with an artifact, restricted to the given namespace if not empty, together with the status of the
+// sources. The bundle can be imported into another Storage with ImportBundle.
+func (s *Storage) ExportBundle(ctx context.Context, c client.Reader, namespace string, w io.Writer) (BundleResult, error) {
+	var result BundleResult
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	var sources []BundleSource
+	for _, k := range sourceKinds {
+		list := k.newList()
+		if err := c.List(ctx, list, client.InNamespace(namespace)); err != nil {
+			return result, fmt.Errorf("failed to list %s objects: %w", k.kind, err)
+		}
+		err := apimeta.EachListItem(list, func(o runtime.Object) error {
+			obj, ok := o.(client.Object)
+			if !ok {
+				return fmt.Errorf("unexpected object type %T", o)
+			}
+			source, ok := obj.(sourcev1.Source)
+			if !ok || source.GetArtifact() == nil {
+				return nil
+			}
+			u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+			if err != nil {
+				return err
+			}
+			status, _, err := unstructured.NestedMap(u, "status")
+			if err != nil {
+				return err
+			}
+
+			n, err := s.exportDir(tw, sourcev1.ArtifactDir(k.kind, obj.GetNamespace(), obj.GetName()))
+			if err != nil {
+				return fmt.Errorf("failed to export artifacts of %s '%s/%s': %w",
+					k.kind, obj.GetNamespace(), obj.GetName(), err)
+			}
+			sources = append(sources, BundleSource{
+				Kind:      k.kind,
+				Namespace: obj.GetNamespace(),
+				Name:      obj.GetName(),
+				Status:    status,
+			})
+			result.Sources++
+			result.Files += n
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+	}
+
+	b, err := json.Marshal(sources)
+	if err != nil {
+		return result, err
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     bundleSourcesName,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     int64(len(b)),
+	}); err != nil {
+		return result, err
+	}
+	if _, err := tw.Write(b); err != nil {
+		return result, err
+	}
+	if err := tw.Close(); err != nil {
+		return result, err
+	}
+	return result, gw.Close()
+}
+
+// exportDir writes the regular files and symlinks in the given artifact directory to the given tar.Writer, and
+// returns the number of entries written. The targets of symlinks are written relative to the Storage.BasePath.
+func (s *Storage) exportDir(tw *tar.Writer, dir string) (int, error) {
+	var n int
+	root := filepath.Join(s.BasePath, filepath.FromSlash(dir))
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(s.BasePath, p)
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Name:    bundleStoragePrefix + filepath.ToSlash(rel),
+			Mode:    0644,
+			ModTime: fi.ModTime(),
+		}
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(p)
+			if err != nil {
+				return err
+			}
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(p), target)
+			}
+			relTarget, err := filepath.Rel(s.BasePath, target)
+			if err != nil {
+				return err
+			}
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = filepath.ToSlash(relTarget)
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+		case fi.Mode().IsRegular():
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = fi.Size()
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			f, err := os.Open(p)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+		n++
+		return nil
+	})
+	return n, err
+}
+
+// ImportBundle extracts the artifacts in the bundle read from the given io.Reader into the storage, and restores
+// the status of the exported sources that exist but do not have an artifact yet. The URLs in the imported statuses
+// are rewritten to the Storage.Scheme and Storage.Hostname, like SetArtifactURL and SetHostname do during
+// reconciliation. Sources that do not exist, or of which the status already has an artifact, are left untouched.
+func (s *Storage) ImportBundle(ctx context.Context, c client.Client, r io.Reader) (BundleResult, error) {
+	var result BundleResult
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return result, err
+	}
+	defer gr.Close()
+
+	var sources []BundleSource
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, err
+		}
+		if hdr.Name == bundleSourcesName {
+			if err := json.NewDecoder(tr).Decode(&sources); err != nil {
+				return result, fmt.Errorf("failed to decode bundle sources: %w", err)
+			}
+			continue
+		}
+		if !strings.HasPrefix(hdr.Name, bundleStoragePrefix) {
+			continue
+		}
+		if err := s.importEntry(hdr, tr); err != nil {
+			return result, fmt.Errorf("failed to import '%s': %w", hdr.Name, err)
+		}
+		result.Files++
+	}
+
+	log := ctrl.LoggerFrom(ctx)
+	for _, source := range sources {
+		result.Sources++
+		imported, err := s.importStatus(ctx, c, source)
+		if err != nil {
+			return result, err
+		}
+		if imported {
+			result.Statuses++
+		} else {
+			log.Info("status not imported, source does not exist or already has an artifact",
+				"kind", source.Kind, "namespace", source.Namespace, "name", source.Name)
+		}
+	}
+	return result, nil
+}
+
+// importEntry writes the given bundle entry to storage.
+func (s *Storage) importEntry(hdr *tar.Header, r io.Reader) error {
+	rel := strings.TrimPrefix(hdr.Name, bundleStoragePrefix)
+	localPath, err := securejoin.SecureJoin(s.BasePath, rel)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(localPath), 0777); err != nil {
+		return err
+	}
+
+	switch hdr.Typeflag {
+	case tar.TypeReg:
+		tf, err := s.createTemp(localPath)
+		if err != nil {
+			return err
+		}
+		tfName := tf.Name()
+		defer s.releaseTemp(tfName)
+		if _, err := io.Copy(tf, r); err != nil {
+			tf.Close()
+			os.Remove(tfName)
+			return err
+		}
+		if err := tf.Close(); err != nil {
+			os.Remove(tfName)
+			return err
+		}
+		if err := os.Chmod(tfName, 0644); err != nil {
+			os.Remove(tfName)
+			return err
+		}
+		if err := fs.RenameWithFallback(tfName, localPath); err != nil {
+			os.Remove(tfName)
+			return err
+		}
+		s.updateUsage(localPath)
+		return os.Chtimes(localPath, hdr.ModTime, hdr.ModTime)
+	case tar.TypeSymlink:
+		target, err := securejoin.SecureJoin(s.BasePath, path.Clean(hdr.Linkname))
+		if err != nil {
+			return err
+		}
+		tmpLink := localPath + ".tmp"
+		if err := os.Remove(tmpLink); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(target, tmpLink); err != nil {
+			return err
+		}
+		return os.Rename(tmpLink, localPath)
+	default:
+		return fmt.Errorf("unsupported entry type '%c'", hdr.Typeflag)
+	}
+}
+
+// importStatus restores the status of the given BundleSource, if the source exists and does not have an artifact.
+// The URLs of the current, retained and included artifacts and their signatures, manifests and metadata are set
+// to the URLs of this Storage. It returns true if the status was restored.
+func (s *Storage) importStatus(ctx context.Context, c client.Client, source BundleSource) (bool, error) {
+	var newObject func() client.Object
+	for _, k := range sourceKinds {
+		if k.kind == source.Kind {
+			newObject = k.newObject
+		}
+	}
+	if newObject == nil {
+		return false, fmt.Errorf("unknown source kind '%s'", source.Kind)
+	}
+
+	obj := newObject()
+	if err := c.Get(ctx, types.NamespacedName{Namespace: source.Namespace, Name: source.Name}, obj); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if obj.(sourcev1.Source).GetArtifact() != nil {
+		return false, nil
+	}
+
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		return false, err
+	}
+	status := runtime.DeepCopyJSON(source.Status)
+	if URL, ok, _ := unstructured.NestedString(status, "url"); ok && URL != "" {
+		if err := unstructured.SetNestedField(status, s.SetHostname(URL), "url"); err != nil {
+			return false, err
+		}
+	}
+	u["status"] = status
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u, obj); err != nil {
+		return false, fmt.Errorf("failed to decode status of %s '%s/%s': %w",
+			source.Kind, source.Namespace, source.Name, err)
+	}
+	artifacts := statusArtifacts(obj)
+	if repository, ok := obj.(*sourcev1.GitRepository); ok {
+		artifacts = append(artifacts, repository.Status.IncludedArtifacts...)
+	}
+	for _, artifact := range artifacts {
+		if artifact != nil {
+			s.SetArtifactURL(artifact)
+		}
+	}
+	if err := c.Status().Patch(ctx, obj, patch); err != nil {
+		return false, fmt.Errorf("failed to import status of %s '%s/%s': %w",
+			source.Kind, source.Namespace, source.Name, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/fluxcd/source-controller/internal/signing"
+)
+
+func TestStorage_ExportImportBundle(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := sourcev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	srcDir, err := createStoragePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanupStoragePath(srcDir))
+	src, err := NewStorage(srcDir, "old-host", time.Minute)
+	if err != nil {
+		t.Fatalf("error while bootstrapping storage: %v", err)
+	}
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Signer, err = signing.NewSigner(key); err != nil {
+		t.Fatal(err)
+	}
+
+	repository := &sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "default"},
+	}
+	retained := src.NewArtifactFor(sourcev1.GitRepositoryKind, repository, "main/000", "000.tar.gz")
+	artifact := src.NewArtifactFor(sourcev1.GitRepositoryKind, repository, "main/abc", "abc.tar.gz")
+	for _, a := range []*sourcev1.Artifact{&retained, &artifact} {
+		if err := src.MkdirAll(*a); err != nil {
+			t.Fatalf("artifact directory creation failed: %v", err)
+		}
+		if err := src.AtomicWriteFile(a, strings.NewReader("artifact"), 0644); err != nil {
+			t.Fatalf("AtomicWriteFile() error = %v", err)
+		}
+	}
+	url, err := src.Symlink(artifact, "latest.tar.gz")
+	if err != nil {
+		t.Fatalf("Symlink() error = %v", err)
+	}
+	included := artifact.DeepCopy()
+	repository.Status.Artifact = &artifact
+	repository.Status.RetainedArtifacts = []sourcev1.Artifact{retained}
+	repository.Status.IncludedArtifacts = []*sourcev1.Artifact{included}
+	repository.Status.URL = url
+	noArtifact := &sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "no-artifact", Namespace: "default"},
+	}
+	otherNamespace := repository.DeepCopy()
+	otherNamespace.Namespace = "other"
+
+	var bundle bytes.Buffer
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(repository, noArtifact, otherNamespace).Build()
+	result, err := src.ExportBundle(context.TODO(), c, "default", &bundle)
+	if err != nil {
+		t.Fatalf("ExportBundle() error = %v", err)
+	}
+	// The two artifacts with their metadata and signatures, and the symlink.
+	if result.Sources != 1 || result.Files != 7 {
+		t.Errorf("ExportBundle() = %+v, want 1 source and 7 files", result)
+	}
+
+	dstDir, err := createStoragePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanupStoragePath(dstDir))
+	dst, err := NewStorage(dstDir, "new-host", time.Minute)
+	if err != nil {
+		t.Fatalf("error while bootstrapping storage: %v", err)
+	}
+	dst.Scheme = "https"
+
+	// The source exists in the new cluster, but has not been reconciled yet.
+	imported := &sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "default"},
+	}
+	c = fake.NewClientBuilder().WithScheme(scheme).WithObjects(imported).Build()
+	result, err = dst.ImportBundle(context.TODO(), c, &bundle)
+	if err != nil {
+		t.Fatalf("ImportBundle() error = %v", err)
+	}
+	if result.Sources != 1 || result.Files != 7 || result.Statuses != 1 {
+		t.Errorf("ImportBundle() = %+v, want 1 source, 7 files and 1 status", result)
+	}
+
+	b, err := os.ReadFile(dst.LocalPath(artifact))
+	if err != nil {
+		t.Fatalf("failed reading imported artifact: %v", err)
+	}
+	if string(b) != "artifact" {
+		t.Errorf("imported artifact content = %q", string(b))
+	}
+	link := filepath.Join(filepath.Dir(dst.LocalPath(artifact)), "latest.tar.gz")
+	target, err := os.Readlink(link)
+	if err != nil {
+		t.Fatalf("failed reading imported symlink: %v", err)
+	}
+	if target != dst.LocalPath(artifact) {
+		t.Errorf("imported symlink target = %q, want %q", target, dst.LocalPath(artifact))
+	}
+
+	if err := c.Get(context.TODO(), types.NamespacedName{Namespace: "default", Name: "podinfo"}, imported); err != nil {
+		t.Fatal(err)
+	}
+	got := imported.GetArtifact()
+	if got == nil {
+		t.Fatal("ImportBundle() did not import status")
+	}
+	if got.Checksum != artifact.Checksum || got.Revision != artifact.Revision {
+		t.Errorf("imported artifact = %+v, want %+v", got, artifact)
+	}
+	if want := "https://new-host/" + artifact.Path; got.URL != want {
+		t.Errorf("imported artifact URL = %q, want %q", got.URL, want)
+	}
+	if want := "https://new-host/gitrepository/default/podinfo/latest.tar.gz"; imported.Status.URL != want {
+		t.Errorf("imported status URL = %q, want %q", imported.Status.URL, want)
+	}
+
+	if len(imported.Status.RetainedArtifacts) != 1 || len(imported.Status.IncludedArtifacts) != 1 {
+		t.Fatalf("ImportBundle() did not import retained and included artifacts: %+v", imported.Status)
+	}
+	for _, a := range []*sourcev1.Artifact{got, &imported.Status.RetainedArtifacts[0], imported.Status.IncludedArtifacts[0]} {
+		if a.Signature == nil || a.Metadata == nil {
+			t.Fatalf("imported artifact %s has no signature or metadata", a.Path)
+		}
+		for _, u := range []string{a.URL, a.Signature.URL, a.Metadata.URL} {
+			if !strings.HasPrefix(u, "https://new-host/") {
+				t.Errorf("imported artifact %s URL = %q, want new host", a.Path, u)
+			}
+		}
+	}
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pmezard/go-difflib/difflib"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/fluxcd/source-controller/internal/fileserver"
+)
+
+// maxDiffFileSize is the maximum size in bytes of a file for which a unified diff is computed.
+const maxDiffFileSize = 1 << 20
+
+var (
+	// maxDiffFiles is the maximum number of files for which unified diffs are computed per Diff.
+	maxDiffFiles = 100
+	// maxDiffBytes is the maximum total size in bytes of the file contents read to compute the unified diffs of
+	// a Diff.
+	maxDiffBytes int64 = 4 << 20
+)
+
+// storedArtifact is an artifact in storage of which the FileManifest is known.
+type storedArtifact struct {
+	localPath   string
+	contentType string
+	manifest    *FileManifest
+}
+
+// Diff returns the files that differ between the artifacts of the given revisions of the source of the given
+// lower case kind, namespace and name, compared by the checksums in their FileManifest. A revision matches an
+// artifact if it equals its revision, or the last slash separated element of it, e.g. the commit SHA of a Git
+// revision. Only artifacts in storage can be compared, which includes the artifacts retained according to the
+// v1beta1.ArtifactRetention of the source.
+// If unified is true, the unified diffs of the changed text files of at most maxDiffFileSize bytes are included,
+// which are read from the archives. Once the unified diffs would exceed maxDiffFiles files or maxDiffBytes bytes
+// of contents, they are omitted for the remaining files, and the Diff is marked as truncated.
+// It implements fileserver.Differ.
+func (s *Storage) Diff(kind, namespace, name, from, to string, unified bool) (*fileserver.Diff, error) {
+	if _, ok := storageKinds[kind]; !ok {
+		return nil, fmt.Errorf("%w: unknown source kind '%s'", fileserver.ErrRevisionNotFound, kind)
+	}
+	dir := filepath.Join(s.BasePath, filepath.FromSlash(sourcev1.ArtifactDir(kind, namespace, name)))
+	fromArtifact, err := s.findArtifact(dir, from)
+	if err != nil {
+		return nil, err
+	}
+	toArtifact, err := s.findArtifact(dir, to)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &fileserver.Diff{
+		From:  fromArtifact.manifest.Revision,
+		To:    toArtifact.manifest.Revision,
+		Files: []fileserver.FileDiff{},
+	}
+	fromFiles := make(map[string]FileManifestEntry, len(fromArtifact.manifest.Files))
+	for _, f := range fromArtifact.manifest.Files {
+		fromFiles[f.Path] = f
+	}
+	toFiles := make(map[string]FileManifestEntry, len(toArtifact.manifest.Files))
+	for _, f := range toArtifact.manifest.Files {
+		toFiles[f.Path] = f
+		old, ok := fromFiles[f.Path]
+		switch {
+		case !ok:
+			diff.Files = append(diff.Files, fileserver.FileDiff{Path: f.Path, Status: fileserver.FileAdded})
+		case old.SHA256 != f.SHA256:
+			diff.Files = append(diff.Files, fileserver.FileDiff{Path: f.Path, Status: fileserver.FileModified})
+		}
+	}
+	for _, f := range fromArtifact.manifest.Files {
+		if _, ok := toFiles[f.Path]; !ok {
+			diff.Files = append(diff.Files, fileserver.FileDiff{Path: f.Path, Status: fileserver.FileRemoved})
+		}
+	}
+	sort.Slice(diff.Files, func(i, j int) bool {
+		return diff.Files[i].Path < diff.Files[j].Path
+	})
+
+	if unified && len(diff.Files) > 0 {
+		if err := s.unifiedDiffs(diff, fromArtifact, toArtifact, fromFiles, toFiles); err != nil {
+			return nil, err
+		}
+	}
+	return diff, nil
+}
+
+// findArtifact returns the most recently written artifact in the given artifact directory that matches the given
+// revision. It returns an error wrapping fileserver.ErrRevisionNotFound if there is none.
+func (s *Storage) findArtifact(dir, revision string) (*storedArtifact, error) {
+	manifests, err := filepath.Glob(filepath.Join(dir, "*"+ManifestExtension))
+	if err != nil {
+		return nil, err
+	}
+
+	var found *storedArtifact
+	var foundModTime int64
+	for _, p := range manifests {
+		localPath := strings.TrimSuffix(p, ManifestExtension)
+		fi, err := os.Stat(localPath)
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		var m FileManifest
+		if err := json.Unmarshal(b, &m); err != nil {
+			continue
+		}
+		if m.Revision != revision && path.Base(m.Revision) != revision {
+			continue
+		}
+		if found == nil || fi.ModTime().UnixNano() > foundModTime {
+			found = &storedArtifact{
+				localPath:   localPath,
+				contentType: contentTypeForFile(localPath),
+				manifest:    &m,
+			}
+			foundModTime = fi.ModTime().UnixNano()
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("%w: '%s'", fileserver.ErrRevisionNotFound, revision)
+	}
+	return found, nil
+}
+
+// unifiedDiffs sets the unified diffs of the changed text files on the given fileserver.Diff, reading their
+// contents from the archives of the given artifacts. The files are diffed in path order within the budget of
+// maxDiffFiles and maxDiffBytes.
+func (s *Storage) unifiedDiffs(diff *fileserver.Diff, from, to *storedArtifact,
+	fromFiles, toFiles map[string]FileManifestEntry) error {
+	fromWanted := make(map[string]struct{})
+	toWanted := make(map[string]struct{})
+	var files int
+	var size int64
+	for _, f := range diff.Files {
+		fromEntry, fromOK := fromFiles[f.Path]
+		toEntry, toOK := toFiles[f.Path]
+		if fromEntry.Size > maxDiffFileSize || toEntry.Size > maxDiffFileSize {
+			continue
+		}
+		if files == maxDiffFiles || size+fromEntry.Size+toEntry.Size > maxDiffBytes {
+			diff.Truncated = true
+			break
+		}
+		files++
+		size += fromEntry.Size + toEntry.Size
+		if fromOK {
+			fromWanted[f.Path] = struct{}{}
+		}
+		if toOK {
+			toWanted[f.Path] = struct{}{}
+		}
+	}
+	fromContents, err := readArchiveFiles(from, fromWanted)
+	if err != nil {
+		return err
+	}
+	toContents, err := readArchiveFiles(to, toWanted)
+	if err != nil {
+		return err
+	}
+
+	for i, f := range diff.Files {
+		a, aOK := fromContents[f.Path]
+		b, bOK := toContents[f.Path]
+		if (f.Status != fileserver.FileAdded && !aOK) || (f.Status != fileserver.FileRemoved && !bOK) {
+			continue
+		}
+		if !isText(a) || !isText(b) {
+			continue
+		}
+		fromFile, toFile := "a/"+f.Path, "b/"+f.Path
+		if f.Status == fileserver.FileAdded {
+			fromFile = "/dev/null"
+		}
+		if f.Status == fileserver.FileRemoved {
+			toFile = "/dev/null"
+		}
+		text, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+			A:        splitLines(string(a)),
+			B:        splitLines(string(b)),
+			FromFile: fromFile,
+			ToFile:   toFile,
+			Context:  3,
+		})
+		if err != nil {
+			return err
+		}
+		diff.Files[i].Diff = text
+	}
+	return nil
+}
+
+// readArchiveFiles returns the contents of the given files in the archive of the given artifact.
+func readArchiveFiles(artifact *storedArtifact, files map[string]struct{}) (map[string][]byte, error) {
+	contents := make(map[string][]byte, len(files))
+	if len(files) == 0 {
+		return contents, nil
+	}
+	f, err := os.Open(artifact.localPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	err = walkArchive(artifact.contentType, f, func(name string, r io.Reader) error {
+		if _, ok := files[name]; !ok {
+			return nil
+		}
+		b, err := io.ReadAll(io.LimitReader(r, maxDiffFileSize+1))
+		if err != nil {
+			return err
+		}
+		if len(b) <= maxDiffFileSize {
+			contents[name] = b
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read artifact '%s': %w", filepath.Base(artifact.localPath), err)
+	}
+	return contents, nil
+}
+
+// contentTypeForFile returns the content type of the archive format of the given file name, which defaults to
+// v1beta1.ArtifactContentTypeGzip.
+func contentTypeForFile(name string) string {
+	for _, f := range archiveFormats {
+		if strings.HasSuffix(name, f.extension) {
+			return f.contentType
+		}
+	}
+	return sourcev1.ArtifactContentTypeGzip
+}
+
+// splitLines splits the given text after every newline.
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// isText returns true if the given content is valid UTF-8 without NUL bytes.
+func isText(b []byte) bool {
+	return utf8.Valid(b) && bytes.IndexByte(b, 0) == -1
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+)
+
+// ArtifactLimitError is returned when the files of a source exceed the v1beta1.ArtifactLimits.
+type ArtifactLimitError struct {
+	// Limit is the name of the exceeded limit field of the v1beta1.ArtifactLimits.
+	Limit string
+	// Max is the value of the exceeded limit.
+	Max int64
+	// Path is the path of the file that exceeded the limit.
+	Path string
+}
+
+func (e *ArtifactLimitError) Error() string {
+	switch e.Limit {
+	case "maxFileSize":
+		return fmt.Sprintf("size of file '%s' exceeds the %d bytes limit", e.Path, e.Max)
+	case "maxFiles":
+		return fmt.Sprintf("number of files exceeds the %d files limit at '%s'", e.Max, e.Path)
+	default:
+		return fmt.Sprintf("total size of files exceeds the %d bytes limit at '%s'", e.Max, e.Path)
+	}
+}
+
+// archiveErrorReason returns the condition reason for the given error returned by Storage.ArchiveWithLimits.
+func archiveErrorReason(err error) string {
+	var limitErr *ArtifactLimitError
+	if errors.As(err, &limitErr) {
+		return sourcev1.ArtifactLimitExceededReason
+	}
+	return sourcev1.StorageOperationFailedReason
+}
+
+// ArtifactLimitsFor returns the artifact limits for a source with the given limits, which can only lower the
+// Storage.ArtifactLimits.
+func (s *Storage) ArtifactLimitsFor(limits *sourcev1.ArtifactLimits) sourcev1.ArtifactLimits {
+	if limits == nil {
+		return s.ArtifactLimits
+	}
+	return sourcev1.ArtifactLimits{
+		MaxSize:     minLimit(s.ArtifactLimits.MaxSize, limits.MaxSize),
+		MaxFiles:    minLimit(s.ArtifactLimits.MaxFiles, limits.MaxFiles),
+		MaxFileSize: minLimit(s.ArtifactLimits.MaxFileSize, limits.MaxFileSize),
+	}
+}
+
+// minLimit returns the lowest of the given limits, where zero is unlimited.
+func minLimit(a, b int64) int64 {
+	if a <= 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// ArtifactLimiter counts the files of a source, and returns an ArtifactLimitError once they exceed the
+// v1beta1.ArtifactLimits.
+type ArtifactLimiter struct {
+	limits sourcev1.ArtifactLimits
+	files  int64
+	size   int64
+}
+
+// NewArtifactLimiter returns an ArtifactLimiter for the given limits.
+func NewArtifactLimiter(limits sourcev1.ArtifactLimits) *ArtifactLimiter {
+	return &ArtifactLimiter{limits: limits}
+}
+
+// Add counts a file with the given path and size, and returns an ArtifactLimitError if a limit is exceeded.
+func (l *ArtifactLimiter) Add(path string, size int64) error {
+	if l.limits.MaxFileSize > 0 && size > l.limits.MaxFileSize {
+		return &ArtifactLimitError{Limit: "maxFileSize", Max: l.limits.MaxFileSize, Path: path}
+	}
+	l.files++
+	if l.limits.MaxFiles > 0 && l.files > l.limits.MaxFiles {
+		return &ArtifactLimitError{Limit: "maxFiles", Max: l.limits.MaxFiles, Path: path}
+	}
+	l.size += size
+	if l.limits.MaxSize > 0 && l.size > l.limits.MaxSize {
+		return &ArtifactLimitError{Limit: "maxSize", Max: l.limits.MaxSize, Path: path}
+	}
+	return nil
+}
+
+// unlimited returns true if none of the limits are set.
+func (l *ArtifactLimiter) unlimited() bool {
+	return l.limits.MaxSize <= 0 && l.limits.MaxFiles <= 0 && l.limits.MaxFileSize <= 0
+}
+
+// limitFilter returns an ArchiveFileFilter that counts the regular files of the given directory that are not matched
+// by the given filter. Once the limits are exceeded, it records the ArtifactLimitError in the given error, and
+// matches all remaining files.
+func (l *ArtifactLimiter) limitFilter(dir string, filter ArchiveFileFilter, limitErr *error) ArchiveFileFilter {
+	if l.unlimited() {
+		return filter
+	}
+	return func(p string, fi os.FileInfo) bool {
+		if filter != nil && filter(p, fi) {
+			return true
+		}
+		if *limitErr != nil {
+			return true
+		}
+		if !fi.Mode().IsRegular() {
+			return false
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			rel = p
+		}
+		if err := l.Add(filepath.ToSlash(rel), fi.Size()); err != nil {
+			*limitErr = err
+			return true
+		}
+		return false
+	}
+}
@@ -0,0 +1,149 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+	"github.com/fluxcd/source-controller/internal/signing"
+)
+
+// ManifestExtension is the extension of the file manifest written next to an archived artifact.
+const ManifestExtension = ".manifest.json"
+
+// sidecarExtensions are the extensions of the files written next to an artifact, which are kept and evicted
+// together with the artifact.
+var sidecarExtensions = []string{signing.SignatureExtension, ManifestExtension, MetadataExtension}
+
+// FileManifest is the manifest of the files in an archived artifact.
+type FileManifest struct {
+	// Revision is the revision of the artifact.
+	Revision string `json:"revision,omitempty"`
+
+	// Files are the regular files in the artifact, sorted by path.
+	Files []FileManifestEntry `json:"files"`
+}
+
+// FileManifestEntry is a regular file in a FileManifest.
+type FileManifestEntry struct {
+	// Path is the slash separated path of the file in the artifact.
+	Path string `json:"path"`
+
+	// Size is the size of the file in bytes.
+	Size int64 `json:"size"`
+
+	// SHA256 is the hex encoded SHA256 checksum of the file.
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest returns the FileManifest of the given v1beta1.Artifact from storage.
+func (s *Storage) Manifest(artifact sourcev1.Artifact) (*FileManifest, error) {
+	if artifact.Manifest == nil {
+		return nil, errors.New("artifact has no manifest")
+	}
+	b, err := os.ReadFile(s.LocalPath(sourcev1.Artifact{Path: artifact.Manifest.Path}))
+	if err != nil {
+		return nil, err
+	}
+	var m FileManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// writeManifest atomically writes the given FileManifest next to the given v1beta1.Artifact, and sets the manifest
+// reference on the artifact.
+func (s *Storage) writeManifest(artifact *sourcev1.Artifact, m *FileManifest) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	manifestPath := artifact.Path + ManifestExtension
+	if err := s.writeSidecar(s.LocalPath(sourcev1.Artifact{Path: manifestPath}), b); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	artifact.Manifest = &sourcev1.ArtifactManifest{
+		Path:     manifestPath,
+		URL:      s.fileURL(manifestPath),
+		Checksum: s.Checksum(bytes.NewReader(b)),
+	}
+	return nil
+}
+
+// buildManifest reads the archive in the format of the given content type in the given file, and returns the
+// FileManifest of the regular files in it.
+func buildManifest(contentType, revision string, f *os.File) (*FileManifest, error) {
+	m := &FileManifest{Revision: revision, Files: []FileManifestEntry{}}
+	err := walkArchive(contentType, f, func(name string, r io.Reader) error {
+		h := newHash()
+		n, err := io.Copy(h, r)
+		if err != nil {
+			return err
+		}
+		m.Files = append(m.Files, FileManifestEntry{
+			Path:   name,
+			Size:   n,
+			SHA256: fmt.Sprintf("%x", h.Sum(nil)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(m.Files, func(i, j int) bool {
+		return m.Files[i].Path < m.Files[j].Path
+	})
+	return m, nil
+}
+
+// walkArchive reads the archive in the format of the given content type in the given file, and calls the given
+// function with the cleaned slash separated path and content of every regular file in it.
+func walkArchive(contentType string, f *os.File, fn func(name string, r io.Reader) error) error {
+	r, err := tarReader(contentType, f)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(path.Clean(filepath.ToSlash(hdr.Name)), tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+)
+
+const (
+	// MetadataExtension is the extension of the MetadataDocument written next to an artifact.
+	MetadataExtension = ".metadata.json"
+
+	// EmbeddedMetadataPath is the reserved path in archived artifacts at which the MetadataDocument is embedded
+	// when Storage.EmbedMetadata is set. A file at this path in the archived directory is excluded from the
+	// archive.
+	EmbeddedMetadataPath = ".flux-metadata.json"
+)
+
+// MetadataDocument describes where an artifact originates from.
+type MetadataDocument struct {
+	// Kind is the kind of the source the artifact was produced for.
+	Kind string `json:"kind"`
+
+	// Namespace is the namespace of the source.
+	Namespace string `json:"namespace"`
+
+	// Name is the name of the source.
+	Name string `json:"name"`
+
+	// Revision is the revision of the artifact.
+	Revision string `json:"revision,omitempty"`
+
+	// Checksum is the SHA256 checksum of the artifact. It is omitted from the embedded document.
+	Checksum string `json:"checksum,omitempty"`
+
+	// CreatedAt is the time the artifact was written. It is omitted from the embedded document.
+	CreatedAt *metav1.Time `json:"createdAt,omitempty"`
+
+	// ControllerVersion is the version of the controller that produced the artifact.
+	ControllerVersion string `json:"controllerVersion,omitempty"`
+
+	// Origin is the URL of the origin the artifact was produced from, e.g. the URL of a Git repository.
+	Origin string `json:"origin,omitempty"`
+}
+
+// WithOrigin returns a copy of the Storage which records the given URL as the origin in the metadata of the
+// artifacts it writes.
+func (s *Storage) WithOrigin(url string) *Storage {
+	c := *s
+	c.origin = url
+	return &c
+}
+
+// metadataFor returns the MetadataDocument of the given v1beta1.Artifact.
+func (s *Storage) metadataFor(artifact sourcev1.Artifact) MetadataDocument {
+	m := MetadataDocument{
+		Revision:          artifact.Revision,
+		Checksum:          artifact.Checksum,
+		ControllerVersion: s.ControllerVersion,
+		Origin:            sanitizeOrigin(s.origin),
+	}
+	if parts := strings.SplitN(artifact.Path, "/", 4); len(parts) == 4 {
+		if kind, ok := storageKinds[parts[0]]; ok {
+			m.Kind = kind
+		}
+		m.Namespace, m.Name = parts[1], parts[2]
+	}
+	if !artifact.LastUpdateTime.IsZero() {
+		t := artifact.LastUpdateTime
+		m.CreatedAt = &t
+	}
+	return m
+}
+
+// embeddedMetadata returns the tarEntry of the MetadataDocument embedded in the archive of the given
+// v1beta1.Artifact, or nil if Storage.EmbedMetadata is not set. The checksum and creation time are omitted, as they
+// are not known while archiving, and would result in different archives for identical directory trees.
+func (s *Storage) embeddedMetadata(artifact sourcev1.Artifact) ([]tarEntry, error) {
+	if !s.EmbedMetadata {
+		return nil, nil
+	}
+	m := s.metadataFor(artifact)
+	m.Checksum, m.CreatedAt = "", nil
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return []tarEntry{{name: EmbeddedMetadataPath, data: b}}, nil
+}
+
+// writeMetadata atomically writes the MetadataDocument of the given v1beta1.Artifact next to it, and sets the
+// metadata reference on the artifact.
+func (s *Storage) writeMetadata(artifact *sourcev1.Artifact) error {
+	b, err := json.Marshal(s.metadataFor(*artifact))
+	if err != nil {
+		return err
+	}
+	metadataPath := artifact.Path + MetadataExtension
+	if err := s.writeSidecar(s.LocalPath(sourcev1.Artifact{Path: metadataPath}), b); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	artifact.Metadata = &sourcev1.ArtifactMetadata{
+		Path: metadataPath,
+		URL:  s.fileURL(metadataPath),
+	}
+	return nil
+}
+
+// excludeEmbeddedMetadata returns an ArchiveFileFilter that excludes the file at the EmbeddedMetadataPath in the
+// given directory, in addition to the files matched by the given ArchiveFileFilter.
+func excludeEmbeddedMetadata(dir string, filter ArchiveFileFilter) ArchiveFileFilter {
+	reserved := filepath.Join(dir, EmbeddedMetadataPath)
+	return func(p string, fi os.FileInfo) bool {
+		if filepath.Clean(p) == reserved {
+			return true
+		}
+		return filter != nil && filter(p, fi)
+	}
+}
+
+// sanitizeOrigin removes the user information from the given origin URL, which may contain credentials.
+func sanitizeOrigin(origin string) string {
+	if i := strings.Index(origin, "://"); i >= 0 {
+		rest := origin[i+3:]
+		end := strings.IndexAny(rest, "/?#")
+		if end < 0 {
+			end = len(rest)
+		}
+		if at := strings.LastIndex(rest[:end], "@"); at >= 0 {
+			return origin[:i+3] + rest[at+1:]
+		}
+	}
+	return origin
+}
@@ -0,0 +1,244 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// OCIConfigMediaType is the media type of the config blob of OCI artifacts.
+	OCIConfigMediaType = "application/vnd.cncf.flux.config.v1+json"
+	// OCIContentMediaType is the media type of the layer of OCI artifacts, which
+	// contains the gzip compressed source tarball.
+	OCIContentMediaType = "application/vnd.cncf.flux.content.v1.tar+gzip"
+
+	// ociContentTitle is the title of the layer of OCI artifacts.
+	ociContentTitle = "source.tar.gz"
+)
+
+// writeOCILayout writes a tarball containing an OCI image layout to the io.Writer. The image has a single layer
+// holding the gzip compressed tarball of the given directory and extra entries, and is annotated with the given
+// revision.
+func (s *Storage) writeOCILayout(w io.Writer, revision, dir string, filter ArchiveFileFilter, extra ...tarEntry) error {
+	layer, err := os.CreateTemp("", "oci-layer-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(layer.Name())
+	defer layer.Close()
+
+	digester := digest.Canonical.Digester()
+	if err := s.writeGzipTar(io.MultiWriter(layer, digester.Hash()), dir, filter, extra...); err != nil {
+		return err
+	}
+	layerSize, err := layer.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := layer.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	config := []byte("{}")
+	manifest, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config: ocispec.Descriptor{
+			MediaType: OCIConfigMediaType,
+			Digest:    digest.FromBytes(config),
+			Size:      int64(len(config)),
+		},
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType: OCIContentMediaType,
+				Digest:    digester.Digest(),
+				Size:      layerSize,
+				Annotations: map[string]string{
+					ocispec.AnnotationTitle: ociContentTitle,
+				},
+			},
+		},
+		Annotations: map[string]string{
+			ocispec.AnnotationRevision: revision,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	index, err := json.Marshal(ocispec.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{
+			{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Digest:    digest.FromBytes(manifest),
+				Size:      int64(len(manifest)),
+				Annotations: map[string]string{
+					ocispec.AnnotationRevision: revision,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	layout, err := json.Marshal(ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+
+	tw := tar.NewWriter(w)
+	for _, d := range []string{"blobs/", "blobs/sha256/"} {
+		if err := tw.WriteHeader(ociTarHeader(d, tar.TypeDir, 0)); err != nil {
+			tw.Close()
+			return err
+		}
+	}
+	for _, blob := range []struct {
+		name string
+		size int64
+		r    io.Reader
+	}{
+		{ociBlobPath(digest.FromBytes(config)), int64(len(config)), bytes.NewReader(config)},
+		{ociBlobPath(digester.Digest()), layerSize, layer},
+		{ociBlobPath(digest.FromBytes(manifest)), int64(len(manifest)), bytes.NewReader(manifest)},
+		{"index.json", int64(len(index)), bytes.NewReader(index)},
+		{ocispec.ImageLayoutFile, int64(len(layout)), bytes.NewReader(layout)},
+	} {
+		if err := tw.WriteHeader(ociTarHeader(blob.name, tar.TypeReg, blob.size)); err != nil {
+			tw.Close()
+			return err
+		}
+		if _, err := io.Copy(tw, blob.r); err != nil {
+			tw.Close()
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// ociBlobPath returns the path of the blob with the given digest in an OCI image layout.
+func ociBlobPath(d digest.Digest) string {
+	return path.Join("blobs", d.Algorithm().String(), d.Encoded())
+}
+
+// ociTarHeader returns a tar.Header without any environment specific data for an OCI image layout entry.
+func ociTarHeader(name string, typeflag byte, size int64) *tar.Header {
+	mode := int64(0644)
+	if typeflag == tar.TypeDir {
+		mode = 0755
+	}
+	return &tar.Header{
+		Typeflag: typeflag,
+		Name:     name,
+		Size:     size,
+		Mode:     mode,
+		ModTime:  time.Unix(0, 0),
+	}
+}
+
+// maxOCIMetadataSize is the max allowed size in bytes of the index and manifest of an OCI image layout.
+const maxOCIMetadataSize = 4 << 20
+
+// ociContentReader returns a reader of the gzip compressed source tarball from the tarball containing an OCI image
+// layout in the given io.ReadSeeker. The content layer is located by following the first manifest of the index.
+func ociContentReader(f io.ReadSeeker) (io.Reader, error) {
+	// Collect the index and manifest candidates, the order of the entries
+	// in the tarball is not guaranteed.
+	metadata := make(map[string][]byte)
+	tr := tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if header.Typeflag != tar.TypeReg || header.Size > maxOCIMetadataSize {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		metadata[path.Clean(header.Name)] = b
+	}
+
+	var index ocispec.Index
+	if err := unmarshalOCIMetadata(metadata, "index.json", &index); err != nil {
+		return nil, err
+	}
+	if len(index.Manifests) == 0 {
+		return nil, fmt.Errorf("OCI image index has no manifests")
+	}
+	var manifest ocispec.Manifest
+	if err := unmarshalOCIMetadata(metadata, ociBlobPath(index.Manifests[0].Digest), &manifest); err != nil {
+		return nil, err
+	}
+	var layer *ocispec.Descriptor
+	for i := range manifest.Layers {
+		if manifest.Layers[i].MediaType == OCIContentMediaType {
+			layer = &manifest.Layers[i]
+			break
+		}
+	}
+	if layer == nil {
+		return nil, fmt.Errorf("OCI image manifest has no layer of media type '%s'", OCIContentMediaType)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	layerPath := ociBlobPath(layer.Digest)
+	tr = tar.NewReader(f)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("OCI image layer '%s' not found", layer.Digest)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if path.Clean(header.Name) == layerPath {
+			return tr, nil
+		}
+	}
+}
+
+func unmarshalOCIMetadata(metadata map[string][]byte, name string, v interface{}) error {
+	b, ok := metadata[name]
+	if !ok {
+		return fmt.Errorf("OCI image layout entry '%s' not found", name)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("failed to decode OCI image layout entry '%s': %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,566 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+)
+
+// ErrStorageQuotaExceeded is returned when an artifact does not fit in the Storage.Quota, even after evicting all
+// the artifacts that are eligible for eviction.
+var ErrStorageQuotaExceeded = errors.New("storage quota exceeded")
+
+// storageRescanInterval is the interval after which the storage is scanned again on the next write when a
+// Storage.Quota is set, to correct the usage for files changed by other means than the Storage.
+var storageRescanInterval = 10 * time.Minute
+
+// storageKinds maps the artifact directory names to the source kinds.
+var storageKinds = map[string]string{
+	strings.ToLower(sourcev1.BucketKind):         sourcev1.BucketKind,
+	strings.ToLower(sourcev1.GitRepositoryKind):  sourcev1.GitRepositoryKind,
+	strings.ToLower(sourcev1.HelmChartKind):      sourcev1.HelmChartKind,
+	strings.ToLower(sourcev1.HelmRepositoryKind): sourcev1.HelmRepositoryKind,
+}
+
+// StorageMetricsRecorder records the usage of the Storage.
+type StorageMetricsRecorder struct {
+	usedBytesGauge   *prometheus.GaugeVec
+	quotaBytesGauge  prometheus.Gauge
+	evictionsCounter *prometheus.CounterVec
+	scrubCounter     *prometheus.CounterVec
+}
+
+// NewStorageMetricsRecorder returns a new StorageMetricsRecorder.
+func NewStorageMetricsRecorder() *StorageMetricsRecorder {
+	return &StorageMetricsRecorder{
+		usedBytesGauge: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "gotk_storage_used_bytes",
+				Help: "The number of bytes used by the artifacts in storage.",
+			},
+			[]string{"kind", "namespace"},
+		),
+		quotaBytesGauge: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "gotk_storage_quota_bytes",
+				Help: "The maximum number of bytes the artifacts in storage may use, zero if unlimited.",
+			},
+		),
+		evictionsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotk_storage_evictions_total",
+				Help: "The total number of artifacts evicted from storage to stay within the quota.",
+			},
+			[]string{"kind", "namespace"},
+		),
+		scrubCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotk_storage_scrub_results_total",
+				Help: "The total number of artifacts verified by the storage scrubber, by result.",
+			},
+			[]string{"kind", "namespace", "result"},
+		),
+	}
+}
+
+// Collectors returns the prometheus.Collector values of the StorageMetricsRecorder.
+func (r *StorageMetricsRecorder) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{r.usedBytesGauge, r.quotaBytesGauge, r.evictionsCounter, r.scrubCounter}
+}
+
+// RecordQuota records the given quota in bytes.
+func (r *StorageMetricsRecorder) RecordQuota(quota int64) {
+	r.quotaBytesGauge.Set(float64(quota))
+}
+
+// RecordUsage records the used bytes of the given files per kind and namespace.
+func (r *StorageMetricsRecorder) RecordUsage(files []storageFile) {
+	used := make(map[[2]string]int64)
+	for _, f := range files {
+		kind, namespace, ok := f.owner()
+		if !ok {
+			continue
+		}
+		used[[2]string{kind, namespace}] += f.size
+	}
+	r.usedBytesGauge.Reset()
+	for k, v := range used {
+		r.usedBytesGauge.WithLabelValues(k[0], k[1]).Set(float64(v))
+	}
+}
+
+// RecordUsageChange records the change of the used bytes by the given file.
+func (r *StorageMetricsRecorder) RecordUsageChange(f storageFile, delta int64) {
+	if kind, namespace, ok := f.owner(); ok && delta != 0 {
+		r.usedBytesGauge.WithLabelValues(kind, namespace).Add(float64(delta))
+	}
+}
+
+// RecordEviction records the eviction of the given file.
+func (r *StorageMetricsRecorder) RecordEviction(f storageFile) {
+	if kind, namespace, ok := f.owner(); ok {
+		r.evictionsCounter.WithLabelValues(kind, namespace).Inc()
+	}
+}
+
+// RecordScrub records the verification of an artifact of the given kind and namespace with the given scrub result.
+func (r *StorageMetricsRecorder) RecordScrub(kind, namespace, result string) {
+	r.scrubCounter.WithLabelValues(kind, namespace, result).Inc()
+}
+
+// storageUsage tracks the size of the files in storage and their total, the last time the artifacts in storage were
+// served, the temporary files of pending writes which must not be evicted, the artifacts last written for every
+// source, and the files marked as corrupted by the StorageScrubber.
+type storageUsage struct {
+	mu         sync.Mutex
+	sizes      map[string]int64
+	used       int64
+	scannedAt  time.Time
+	lastServed map[string]time.Time
+	pending    map[string]struct{}
+	latest     map[string]string
+	corrupted  map[string]os.FileInfo
+
+	// evictMu serializes the scans and evictions of the storage.
+	evictMu sync.Mutex
+}
+
+func newStorageUsage() *storageUsage {
+	return &storageUsage{
+		lastServed: make(map[string]time.Time),
+		pending:    make(map[string]struct{}),
+		latest:     make(map[string]string),
+		corrupted:  make(map[string]os.FileInfo),
+	}
+}
+
+// storageFile is a regular file in storage.
+type storageFile struct {
+	// path is the path of the file relative to the Storage.BasePath, in the format of v1beta1.Artifact.Path.
+	path      string
+	localPath string
+	size      int64
+	lastUsed  time.Time
+}
+
+// owner returns the kind and namespace of the source the file belongs to.
+func (f storageFile) owner() (kind, namespace string, ok bool) {
+	parts := strings.SplitN(f.path, "/", 4)
+	if len(parts) != 4 {
+		return "", "", false
+	}
+	kind, ok = storageKinds[parts[0]]
+	return kind, parts[1], ok
+}
+
+// ArtifactLister lists the artifacts in use by the sources, which are never evicted from the Storage.
+type ArtifactLister interface {
+	ListArtifacts(ctx context.Context) ([]sourcev1.Artifact, error)
+}
+
+// ArtifactListerFunc is an ArtifactLister function.
+type ArtifactListerFunc func(ctx context.Context) ([]sourcev1.Artifact, error)
+
+// ListArtifacts calls f(ctx).
+func (f ArtifactListerFunc) ListArtifacts(ctx context.Context) ([]sourcev1.Artifact, error) {
+	return f(ctx)
+}
+
+// SourceArtifactLister is an ArtifactLister of the current and retained artifacts in the status of the sources.
+type SourceArtifactLister struct {
+	client.Reader
+
+	// Namespace restricts the listing to the sources in the given namespace. It must be set when the Reader only has
+	// access to the sources in a single namespace.
+	Namespace string
+}
+
+// ListArtifacts returns the current and retained artifacts of all sources.
+func (l SourceArtifactLister) ListArtifacts(ctx context.Context) ([]sourcev1.Artifact, error) {
+	var artifacts []sourcev1.Artifact
+	for _, k := range sourceKinds {
+		list := k.newList()
+		if err := l.List(ctx, list, client.InNamespace(l.Namespace)); err != nil {
+			return nil, fmt.Errorf("failed to list %s objects: %w", k.kind, err)
+		}
+		err := apimeta.EachListItem(list, func(o runtime.Object) error {
+			for _, a := range statusArtifacts(o) {
+				artifacts = append(artifacts, *a)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return artifacts, nil
+}
+
+// statusArtifacts returns the current and retained artifacts in the status of the given source object.
+func statusArtifacts(obj runtime.Object) []*sourcev1.Artifact {
+	var current *sourcev1.Artifact
+	var retained []sourcev1.Artifact
+	switch o := obj.(type) {
+	case *sourcev1.Bucket:
+		current, retained = o.Status.Artifact, o.Status.RetainedArtifacts
+	case *sourcev1.GitRepository:
+		current, retained = o.Status.Artifact, o.Status.RetainedArtifacts
+	case *sourcev1.HelmChart:
+		current, retained = o.Status.Artifact, o.Status.RetainedArtifacts
+	case *sourcev1.HelmRepository:
+		current, retained = o.Status.Artifact, o.Status.RetainedArtifacts
+	}
+	var artifacts []*sourcev1.Artifact
+	if current != nil {
+		artifacts = append(artifacts, current)
+	}
+	for i := range retained {
+		artifacts = append(artifacts, &retained[i])
+	}
+	return artifacts
+}
+
+// RecordAccess records that the artifact at the given path, relative to the Storage.BasePath, has been served.
+// The access is only recorded when a Storage.Quota is set, as it only orders the artifacts for eviction.
+// It implements fileserver.AccessRecorder.
+func (s *Storage) RecordAccess(path string) {
+	if s.usage == nil || s.Quota <= 0 {
+		return
+	}
+	s.usage.mu.Lock()
+	s.usage.lastServed[path] = time.Now()
+	s.usage.mu.Unlock()
+}
+
+// createTemp creates a new temporary file in the directory of the given local path, which is excluded from eviction
+// until it is released with releaseTemp.
+func (s *Storage) createTemp(localPath string) (*os.File, error) {
+	tf, err := os.CreateTemp(filepath.Split(localPath))
+	if err != nil {
+		return nil, err
+	}
+	if s.usage != nil {
+		s.usage.mu.Lock()
+		s.usage.pending[tf.Name()] = struct{}{}
+		s.usage.mu.Unlock()
+	}
+	return tf, nil
+}
+
+// releaseTemp releases the temporary file with the given name created by createTemp.
+func (s *Storage) releaseTemp(name string) {
+	if s.usage == nil {
+		return
+	}
+	s.usage.mu.Lock()
+	delete(s.usage.pending, name)
+	s.usage.mu.Unlock()
+}
+
+// reserve ensures that the artifacts in storage stay within the Storage.Quota once the given temporary file is
+// renamed to the given local path. If they would not, the least recently served artifacts that are not in use are
+// evicted until they do. An artifact is in use if it is listed by the Storage.InUse lister, which lists the current
+// and retained artifacts in the status of the sources, if it is the artifact last written for its source, which may
+// not be in the status of the source yet, or if it is the artifact being written. The signature and manifest of an
+// artifact are evicted together with the artifact, lock and temporary files are never evicted.
+// It returns an error wrapping ErrStorageQuotaExceeded without evicting any artifact if the artifact would not fit
+// in the quota even after evicting all the eligible artifacts.
+// The artifacts in use are only listed, and the storage only scanned, when the artifact does not fit in the quota
+// according to the usage kept by updateUsage, or when the storage has not been scanned for storageRescanInterval.
+// The storage is never scanned when no quota is set.
+func (s *Storage) reserve(tmpName, localPath string) error {
+	if s.usage == nil || s.Quota <= 0 {
+		return nil
+	}
+	s.usage.evictMu.Lock()
+	defer s.usage.evictMu.Unlock()
+
+	tfi, err := os.Stat(tmpName)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(s.BasePath, localPath)
+	if err != nil {
+		return err
+	}
+	s.usage.mu.Lock()
+	fresh := s.usage.sizes != nil && time.Since(s.usage.scannedAt) < storageRescanInterval
+	used := s.usage.used - s.usage.sizes[filepath.ToSlash(rel)] + tfi.Size()
+	if fresh && used <= s.Quota {
+		s.usage.latest[filepath.Dir(localPath)] = localPath
+		s.usage.mu.Unlock()
+		return nil
+	}
+	s.usage.mu.Unlock()
+
+	inUse, err := s.artifactsInUse()
+	if err != nil {
+		return fmt.Errorf("failed to list the artifacts in use: %w", err)
+	}
+	files, err := s.scan()
+	if err != nil {
+		return fmt.Errorf("failed to calculate storage usage: %w", err)
+	}
+
+	var evictable int64
+	var kept, candidates []storageFile
+	used = 0
+	s.usage.mu.Lock()
+	for _, latest := range s.usage.latest {
+		inUse[latest] = struct{}{}
+	}
+	for _, f := range files {
+		// The file at the local path is replaced by the temporary file.
+		if f.localPath == localPath {
+			continue
+		}
+		if f.localPath == tmpName {
+			f.path, f.localPath = filepath.ToSlash(rel), localPath
+		}
+		used += f.size
+		// The temporary files of other pending writes are recorded once renamed.
+		if _, ok := s.usage.pending[f.localPath]; ok {
+			continue
+		}
+		kept = append(kept, f)
+
+		if _, ok := inUse[f.localPath]; ok {
+			continue
+		}
+		if f.localPath == localPath || isSidecar(f.localPath) || isStorageInternal(f.localPath) {
+			continue
+		}
+		candidates = append(candidates, f)
+		evictable += f.size
+	}
+	s.usage.mu.Unlock()
+
+	if used-evictable > s.Quota {
+		return fmt.Errorf("%w: %d bytes required with a quota of %d bytes", ErrStorageQuotaExceeded, used, s.Quota)
+	}
+	if used > s.Quota {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return candidates[i].lastUsed.Before(candidates[j].lastUsed)
+		})
+		evicted := make(map[string]struct{})
+		for _, c := range candidates {
+			if used <= s.Quota {
+				break
+			}
+			if err := os.Remove(c.localPath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to evict artifact '%s': %w", c.path, err)
+			}
+			evicted[c.localPath] = struct{}{}
+			used -= c.size
+			for _, ext := range sidecarExtensions {
+				sidecar := c.localPath + ext
+				if fi, err := os.Stat(sidecar); err == nil {
+					if err := os.Remove(sidecar); err == nil {
+						evicted[sidecar] = struct{}{}
+						used -= fi.Size()
+					}
+				}
+			}
+			if s.Metrics != nil {
+				s.Metrics.RecordEviction(c)
+			}
+		}
+		remaining := kept[:0]
+		for _, f := range kept {
+			if _, ok := evicted[f.localPath]; !ok {
+				remaining = append(remaining, f)
+			}
+		}
+		kept = remaining
+	}
+
+	s.usage.mu.Lock()
+	s.usage.latest[filepath.Dir(localPath)] = localPath
+	s.usage.mu.Unlock()
+	s.recordUsage(kept)
+	return nil
+}
+
+// artifactsInUse returns the local paths of the artifacts listed by the Storage.InUse lister.
+func (s *Storage) artifactsInUse() (map[string]struct{}, error) {
+	inUse := make(map[string]struct{})
+	if s.InUse == nil {
+		return inUse, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	artifacts, err := s.InUse.ListArtifacts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range artifacts {
+		if localPath := s.LocalPath(a); localPath != "" {
+			inUse[localPath] = struct{}{}
+		}
+	}
+	return inUse, nil
+}
+
+// recordUsage records the given files as the files in storage, and their usage with the Storage.Metrics, if set.
+func (s *Storage) recordUsage(files []storageFile) {
+	sizes := make(map[string]int64, len(files))
+	var used int64
+	for _, f := range files {
+		sizes[f.path] = f.size
+		used += f.size
+	}
+	s.usage.mu.Lock()
+	s.usage.sizes, s.usage.used, s.usage.scannedAt = sizes, used, time.Now()
+	s.usage.mu.Unlock()
+	if s.Metrics != nil {
+		s.Metrics.RecordUsage(files)
+	}
+}
+
+// updateUsage updates the usage of the storage, which is recorded with the Storage.Metrics, if set, and kept for the
+// Storage.Quota, if set, after the files at the given local paths have been written or removed. When a local path no
+// longer exists, the files that were under it are removed from the usage as well. The storage is scanned once to
+// record the initial usage.
+func (s *Storage) updateUsage(localPaths ...string) {
+	if s.usage == nil || (s.Metrics == nil && s.Quota <= 0) {
+		return
+	}
+	s.usage.mu.Lock()
+	scanned := s.usage.sizes != nil
+	s.usage.mu.Unlock()
+	if !scanned {
+		s.usage.evictMu.Lock()
+		defer s.usage.evictMu.Unlock()
+		if files, err := s.scan(); err == nil {
+			s.recordUsage(files)
+		}
+		return
+	}
+
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	for _, localPath := range localPaths {
+		rel, err := filepath.Rel(s.BasePath, localPath)
+		if err != nil {
+			continue
+		}
+		p := filepath.ToSlash(rel)
+		if fi, err := os.Lstat(localPath); err == nil && fi.Mode().IsRegular() {
+			s.changeUsage(p, fi.Size()-s.usage.sizes[p])
+			s.usage.sizes[p] = fi.Size()
+			continue
+		}
+		for f, size := range s.usage.sizes {
+			if f == p || strings.HasPrefix(f, p+"/") {
+				s.changeUsage(f, -size)
+				delete(s.usage.sizes, f)
+			}
+		}
+	}
+}
+
+// changeUsage changes the usage by the file at the given path by the given number of bytes. It must be called with
+// the usage mutex held.
+func (s *Storage) changeUsage(path string, delta int64) {
+	s.usage.used += delta
+	if s.Metrics != nil {
+		s.Metrics.RecordUsageChange(storageFile{path: path}, delta)
+	}
+}
+
+// isSidecar returns true if the given path is a file written next to an artifact.
+func isSidecar(path string) bool {
+	for _, ext := range sidecarExtensions {
+		if strings.HasSuffix(path, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// isStorageInternal returns true if the given path is a lock file or temporary file of the Storage, which are never
+// evicted.
+func isStorageInternal(path string) bool {
+	return strings.HasSuffix(path, ".lock") || strings.HasSuffix(path, ".tmp")
+}
+
+// scan walks the Storage.BasePath, and returns the regular files in storage. The last served times of files that no
+// longer exist are discarded, as are the artifacts last written for sources of which the artifact directory no
+// longer exists.
+func (s *Storage) scan() ([]storageFile, error) {
+	var files []storageFile
+	err := filepath.Walk(s.BasePath, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.Mode().IsRegular() {
+			rel, err := filepath.Rel(s.BasePath, p)
+			if err != nil {
+				return err
+			}
+			files = append(files, storageFile{
+				path:      filepath.ToSlash(rel),
+				localPath: p,
+				size:      fi.Size(),
+				lastUsed:  fi.ModTime(),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	exists := make(map[string]struct{}, len(files))
+	for i, f := range files {
+		exists[f.path] = struct{}{}
+		if t, ok := s.usage.lastServed[f.path]; ok && t.After(f.lastUsed) {
+			files[i].lastUsed = t
+		}
+	}
+	for p := range s.usage.lastServed {
+		if _, ok := exists[p]; !ok {
+			delete(s.usage.lastServed, p)
+		}
+	}
+	for dir := range s.usage.latest {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			delete(s.usage.latest, dir)
+		}
+	}
+	return files, nil
+}
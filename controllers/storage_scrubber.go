@@ -0,0 +1,258 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-logr/logr"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fluxcd/pkg/apis/meta"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+)
+
+const (
+	// ScrubResultVerified is the result of an artifact of which the checksum matches the file in storage.
+	ScrubResultVerified = "verified"
+	// ScrubResultMissing is the result of an artifact of which the file does not exist in storage.
+	ScrubResultMissing = "missing"
+	// ScrubResultMismatch is the result of an artifact of which the checksum does not match the file in storage.
+	ScrubResultMismatch = "mismatch"
+)
+
+// StorageScrubber periodically verifies the integrity of the artifacts in the Storage.
+//
+// The file of the current artifact of every source is hashed and compared to the Checksum of the artifact. When the
+// file is missing or does not match, the reconciliation of the source is requested with the
+// meta.ReconcileRequestAnnotation. A corrupted file is not removed, as it may have been replaced by a reconciliation
+// that has not updated the status yet, but marked with Storage.MarkCorrupted, which results in the artifact being
+// produced again.
+type StorageScrubber struct {
+	client.Client
+
+	// APIReader is used to read the sources bypassing the cache before acting on a failed verification. The Client
+	// is used if not set.
+	APIReader client.Reader
+
+	Storage *Storage
+
+	// Namespace restricts the verification to the artifacts of sources in the given namespace. It must be set
+	// when the Client only has access to the sources in a single namespace.
+	Namespace string
+
+	// Interval is the interval at which the artifacts are verified.
+	Interval time.Duration
+}
+
+// Start verifies the artifacts at every Interval until the context is cancelled.
+// It implements manager.Runnable.
+func (s *StorageScrubber) Start(ctx context.Context) error {
+	log := ctrl.Log.WithName("storage-scrubber")
+	ctx = logr.NewContext(ctx, log)
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+		if _, err := s.Scrub(ctx); err != nil {
+			log.Error(err, "failed to verify artifacts")
+		}
+	}
+}
+
+// NeedLeaderElection returns true, as the storage is only written by the leader.
+// It implements manager.LeaderElectionRunnable.
+func (s *StorageScrubber) NeedLeaderElection() bool {
+	return true
+}
+
+// Scrub verifies the current artifacts of all sources, and returns the number of artifacts per scrub result.
+func (s *StorageScrubber) Scrub(ctx context.Context) (map[string]int, error) {
+	results := make(map[string]int)
+	for _, k := range sourceKinds {
+		list := k.newList()
+		if err := s.List(ctx, list, client.InNamespace(s.Namespace)); err != nil {
+			return results, fmt.Errorf("failed to list %s objects: %w", k.kind, err)
+		}
+		err := apimeta.EachListItem(list, func(o runtime.Object) error {
+			obj, ok := o.(client.Object)
+			if !ok {
+				return fmt.Errorf("unexpected object type %T", o)
+			}
+			result, err := s.scrub(ctx, k.kind, obj)
+			if err != nil {
+				return err
+			}
+			if result != "" {
+				results[result]++
+			}
+			return nil
+		})
+		if err != nil {
+			return results, err
+		}
+	}
+	return results, nil
+}
+
+// scrub verifies the current artifact of the given source object, and returns the scrub result, or an empty string
+// if the object has no artifact.
+func (s *StorageScrubber) scrub(ctx context.Context, kind string, obj client.Object) (string, error) {
+	artifact := currentArtifact(obj)
+	if artifact == nil {
+		return "", nil
+	}
+
+	result, err := s.verify(*artifact)
+	if err != nil {
+		return "", err
+	}
+	if result != ScrubResultVerified {
+		// The listed object may be outdated, verify the artifact again while it can not be written, with the
+		// latest state of the object.
+		unlock, err := s.Storage.Lock(*artifact)
+		if err != nil {
+			return "", fmt.Errorf("unable to acquire lock: %w", err)
+		}
+		defer unlock()
+
+		if err := s.apiReader().Get(ctx, client.ObjectKeyFromObject(obj), obj); err != nil {
+			return "", client.IgnoreNotFound(err)
+		}
+		if artifact = currentArtifact(obj); artifact == nil {
+			return "", nil
+		}
+		if result, err = s.verify(*artifact); err != nil {
+			return "", err
+		}
+	}
+	if s.Storage.Metrics != nil {
+		s.Storage.Metrics.RecordScrub(kind, obj.GetNamespace(), result)
+	}
+	if result == ScrubResultVerified {
+		return result, nil
+	}
+
+	log := ctrl.LoggerFrom(ctx).WithValues(
+		"kind", kind,
+		"namespace", obj.GetNamespace(),
+		"name", obj.GetName(),
+		"path", artifact.Path,
+		"result", result,
+	)
+	log.Info("artifact failed verification, requesting reconciliation")
+
+	if result == ScrubResultMismatch {
+		s.Storage.MarkCorrupted(*artifact)
+	}
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	annotations := obj.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[meta.ReconcileRequestAnnotation] = time.Now().Format(time.RFC3339Nano)
+	obj.SetAnnotations(annotations)
+	if err := s.Patch(ctx, obj, patch); err != nil {
+		return result, fmt.Errorf("failed to request reconciliation of %s '%s/%s': %w",
+			kind, obj.GetNamespace(), obj.GetName(), err)
+	}
+	return result, nil
+}
+
+// apiReader returns the APIReader, or the Client if not set.
+func (s *StorageScrubber) apiReader() client.Reader {
+	if s.APIReader != nil {
+		return s.APIReader
+	}
+	return s.Client
+}
+
+// currentArtifact returns the current artifact of the given source object, or nil if it has none or is being
+// deleted.
+func currentArtifact(obj client.Object) *sourcev1.Artifact {
+	source, ok := obj.(sourcev1.Source)
+	if !ok || source.GetArtifact() == nil || !obj.GetDeletionTimestamp().IsZero() {
+		return nil
+	}
+	artifact := *source.GetArtifact()
+	return &artifact
+}
+
+// verify hashes the file of the given artifact, and returns the scrub result.
+func (s *StorageScrubber) verify(artifact sourcev1.Artifact) (string, error) {
+	f, err := os.Open(s.Storage.LocalPath(artifact))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ScrubResultMissing, nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	if fmt.Sprintf("%x", h.Sum(nil)) != artifact.Checksum {
+		return ScrubResultMismatch, nil
+	}
+	return ScrubResultVerified, nil
+}
+
+// MarkCorrupted marks the file of the given artifact as corrupted, which makes ArtifactExist return false for the
+// artifact until the file is replaced.
+func (s *Storage) MarkCorrupted(artifact sourcev1.Artifact) {
+	fi, err := os.Lstat(s.LocalPath(artifact))
+	if err != nil || s.usage == nil {
+		return
+	}
+	s.usage.mu.Lock()
+	s.usage.corrupted[artifact.Path] = fi
+	s.usage.mu.Unlock()
+}
+
+// isCorrupted returns true if the given file of the artifact at the given path has been marked as corrupted. A mark
+// of a file that has since been replaced is discarded.
+func (s *Storage) isCorrupted(path string, fi os.FileInfo) bool {
+	if s.usage == nil {
+		return false
+	}
+	s.usage.mu.Lock()
+	defer s.usage.mu.Unlock()
+	marked, ok := s.usage.corrupted[path]
+	if !ok {
+		return false
+	}
+	if !os.SameFile(marked, fi) || !marked.ModTime().Equal(fi.ModTime()) {
+		delete(s.usage.corrupted, path)
+		return false
+	}
+	return true
+}
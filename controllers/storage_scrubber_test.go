@@ -0,0 +1,191 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/fluxcd/pkg/apis/meta"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+)
+
+func TestStorageScrubber_Scrub(t *testing.T) {
+	dir, err := createStoragePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanupStoragePath(dir))
+
+	storage, err := NewStorage(dir, "hostname", time.Minute)
+	if err != nil {
+		t.Fatalf("error while bootstrapping storage: %v", err)
+	}
+	storage.Metrics = NewStorageMetricsRecorder()
+
+	newRepository := func(name string, write bool) *sourcev1.GitRepository {
+		t.Helper()
+		repository := &sourcev1.GitRepository{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+		}
+		artifact := storage.NewArtifactFor(sourcev1.GitRepositoryKind, repository, "revision", "revision.txt")
+		if err := storage.MkdirAll(artifact); err != nil {
+			t.Fatalf("artifact directory creation failed: %v", err)
+		}
+		if err := storage.AtomicWriteFile(&artifact, strings.NewReader(name), 0644); err != nil {
+			t.Fatalf("AtomicWriteFile() error = %v", err)
+		}
+		if !write {
+			if err := os.Remove(storage.LocalPath(artifact)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		repository.Status.Artifact = &artifact
+		return repository
+	}
+	verified := newRepository("verified", true)
+	corrupted := newRepository("corrupted", true)
+	missing := newRepository("missing", false)
+	if err := os.WriteFile(storage.LocalPath(*corrupted.GetArtifact()), []byte("truncat"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	noArtifact := &sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "no-artifact", Namespace: "default"},
+	}
+
+	scheme := runtime.NewScheme()
+	if err := sourcev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(verified, corrupted, missing, noArtifact).Build()
+
+	scrubber := &StorageScrubber{Client: c, Storage: storage}
+	results, err := scrubber.Scrub(context.TODO())
+	if err != nil {
+		t.Fatalf("Scrub() error = %v", err)
+	}
+	want := map[string]int{ScrubResultVerified: 1, ScrubResultMismatch: 1, ScrubResultMissing: 1}
+	for k, v := range want {
+		if results[k] != v {
+			t.Errorf("Scrub() results = %v, want %v", results, want)
+			break
+		}
+	}
+
+	if storage.ArtifactExist(*corrupted.GetArtifact()) {
+		t.Error("Scrub() did not mark corrupted artifact")
+	}
+	if _, err := os.Stat(storage.LocalPath(*corrupted.GetArtifact())); err != nil {
+		t.Errorf("Scrub() removed corrupted artifact: %v", err)
+	}
+	if !storage.ArtifactExist(*verified.GetArtifact()) {
+		t.Error("Scrub() marked verified artifact")
+	}
+
+	for _, tt := range []struct {
+		obj  *sourcev1.GitRepository
+		want bool
+	}{
+		{verified, false},
+		{corrupted, true},
+		{missing, true},
+		{noArtifact, false},
+	} {
+		var got sourcev1.GitRepository
+		if err := c.Get(context.TODO(), client.ObjectKeyFromObject(tt.obj), &got); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := got.GetAnnotations()[meta.ReconcileRequestAnnotation]; ok != tt.want {
+			t.Errorf("%s reconcile request annotation = %v, want %v", tt.obj.Name, ok, tt.want)
+		}
+	}
+
+	if got := testutil.ToFloat64(storage.Metrics.scrubCounter.WithLabelValues(sourcev1.GitRepositoryKind, "default", ScrubResultMismatch)); got != 1 {
+		t.Errorf("mismatch results = %v, want 1", got)
+	}
+
+	// Replacing the corrupted file discards the mark.
+	artifact := *corrupted.GetArtifact()
+	if err := storage.AtomicWriteFile(&artifact, strings.NewReader("corrupted"), 0644); err != nil {
+		t.Fatalf("AtomicWriteFile() error = %v", err)
+	}
+	if !storage.ArtifactExist(artifact) {
+		t.Error("ArtifactExist() = false after replacing corrupted artifact")
+	}
+}
+
+func TestStorageScrubber_Scrub_outdatedList(t *testing.T) {
+	dir, err := createStoragePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanupStoragePath(dir))
+
+	storage, err := NewStorage(dir, "hostname", time.Minute)
+	if err != nil {
+		t.Fatalf("error while bootstrapping storage: %v", err)
+	}
+
+	// The artifact is rewritten at the same path by a reconciliation, which is not yet reflected by the cached
+	// object.
+	outdated := &sourcev1.HelmChart{
+		ObjectMeta: metav1.ObjectMeta{Name: "podinfo", Namespace: "default"},
+	}
+	artifact := storage.NewArtifactFor(sourcev1.HelmChartKind, outdated, "6.0.0", "podinfo-6.0.0.tgz")
+	if err := storage.MkdirAll(artifact); err != nil {
+		t.Fatalf("artifact directory creation failed: %v", err)
+	}
+	if err := storage.AtomicWriteFile(&artifact, strings.NewReader("old"), 0644); err != nil {
+		t.Fatalf("AtomicWriteFile() error = %v", err)
+	}
+	outdated.Status.Artifact = artifact.DeepCopy()
+	latest := outdated.DeepCopy()
+	if err := storage.AtomicWriteFile(&artifact, strings.NewReader("new"), 0644); err != nil {
+		t.Fatalf("AtomicWriteFile() error = %v", err)
+	}
+	latest.Status.Artifact = &artifact
+
+	scheme := runtime.NewScheme()
+	if err := sourcev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	scrubber := &StorageScrubber{
+		Client:    fake.NewClientBuilder().WithScheme(scheme).WithObjects(outdated).Build(),
+		APIReader: fake.NewClientBuilder().WithScheme(scheme).WithObjects(latest).Build(),
+		Storage:   storage,
+	}
+	results, err := scrubber.Scrub(context.TODO())
+	if err != nil {
+		t.Fatalf("Scrub() error = %v", err)
+	}
+	if results[ScrubResultVerified] != 1 || len(results) != 1 {
+		t.Errorf("Scrub() results = %v, want 1 verified", results)
+	}
+	if !storage.ArtifactExist(artifact) {
+		t.Error("Scrub() marked rewritten artifact")
+	}
+}
@@ -0,0 +1,238 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	apimeta "k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+)
+
+// StorageSweeper removes the artifacts of sources that no longer exist from the Storage.
+//
+// The artifacts of a source are normally removed by the garbage collection of its reconciler when the source is
+// deleted. Artifacts of sources deleted while the controller was not running, or of which the finalizer was removed,
+// are left behind in their artifact directory. The StorageSweeper lists the artifact directories in storage, and
+// removes the ones that do not belong to an existing source, and of which the contents have not been modified for
+// the GracePeriod.
+type StorageSweeper struct {
+	client.Reader
+
+	Storage *Storage
+
+	// Namespace restricts the sweep to the artifact directories of sources in the given namespace. It must be set
+	// when the Reader only has access to the sources in a single namespace.
+	Namespace string
+
+	// Interval is the interval at which the storage is swept after the initial sweep at startup.
+	Interval time.Duration
+
+	// GracePeriod is the minimum duration an orphaned artifact directory has not been modified before it is removed.
+	GracePeriod time.Duration
+
+	// DryRun logs the orphaned artifact directories instead of removing them.
+	DryRun bool
+}
+
+// sourceKinds are the source kinds with artifacts in storage, with their object and list types.
+var sourceKinds = []struct {
+	kind      string
+	newObject func() client.Object
+	newList   func() client.ObjectList
+}{
+	{
+		sourcev1.BucketKind,
+		func() client.Object { return &sourcev1.Bucket{} },
+		func() client.ObjectList { return &sourcev1.BucketList{} },
+	},
+	{
+		sourcev1.GitRepositoryKind,
+		func() client.Object { return &sourcev1.GitRepository{} },
+		func() client.ObjectList { return &sourcev1.GitRepositoryList{} },
+	},
+	{
+		sourcev1.HelmChartKind,
+		func() client.Object { return &sourcev1.HelmChart{} },
+		func() client.ObjectList { return &sourcev1.HelmChartList{} },
+	},
+	{
+		sourcev1.HelmRepositoryKind,
+		func() client.Object { return &sourcev1.HelmRepository{} },
+		func() client.ObjectList { return &sourcev1.HelmRepositoryList{} },
+	},
+}
+
+// Start sweeps the storage, and then sweeps it again at every Interval until the context is cancelled.
+// It implements manager.Runnable.
+func (s *StorageSweeper) Start(ctx context.Context) error {
+	log := ctrl.Log.WithName("storage-sweeper")
+	ctx = logr.NewContext(ctx, log)
+
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := s.Sweep(ctx); err != nil {
+			log.Error(err, "failed to sweep storage")
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// NeedLeaderElection returns true, as the storage is only written by the leader.
+// It implements manager.LeaderElectionRunnable.
+func (s *StorageSweeper) NeedLeaderElection() bool {
+	return true
+}
+
+// Sweep removes the orphaned artifact directories from the storage, and returns their paths relative to the
+// Storage.BasePath. In DryRun mode, the orphaned artifact directories are returned without being removed.
+func (s *StorageSweeper) Sweep(ctx context.Context) ([]string, error) {
+	log := ctrl.LoggerFrom(ctx)
+
+	var orphans []string
+	for _, k := range sourceKinds {
+		live, err := s.listArtifactDirs(ctx, k.kind, k.newList())
+		if err != nil {
+			return orphans, err
+		}
+
+		dirs, err := s.artifactDirs(k.kind)
+		if err != nil {
+			return orphans, err
+		}
+		for _, dir := range dirs {
+			if _, ok := live[dir]; ok {
+				continue
+			}
+			localPath := filepath.Join(s.Storage.BasePath, filepath.FromSlash(dir))
+			modified, err := lastModified(localPath)
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return orphans, err
+			}
+			if time.Since(modified) < s.GracePeriod {
+				continue
+			}
+
+			orphans = append(orphans, dir)
+			if s.DryRun {
+				log.Info("found orphaned artifact directory", "path", dir, "lastModified", modified)
+				continue
+			}
+			err = os.RemoveAll(localPath)
+			s.Storage.updateUsage(localPath)
+			if err != nil {
+				return orphans, fmt.Errorf("failed to remove orphaned artifact directory '%s': %w", dir, err)
+			}
+			// Remove the namespace directory if this was its last source.
+			_ = os.Remove(filepath.Dir(localPath))
+			log.Info("removed orphaned artifact directory", "path", dir, "lastModified", modified)
+		}
+	}
+	return orphans, nil
+}
+
+// listArtifactDirs returns the artifact directories of the existing sources of the given kind, listed in the given
+// client.ObjectList.
+func (s *StorageSweeper) listArtifactDirs(ctx context.Context, kind string, list client.ObjectList) (map[string]struct{}, error) {
+	if err := s.List(ctx, list, client.InNamespace(s.Namespace)); err != nil {
+		return nil, fmt.Errorf("failed to list %s objects: %w", kind, err)
+	}
+
+	dirs := make(map[string]struct{})
+	err := apimeta.EachListItem(list, func(o runtime.Object) error {
+		m, err := apimeta.Accessor(o)
+		if err != nil {
+			return err
+		}
+		dirs[sourcev1.ArtifactDir(kind, m.GetNamespace(), m.GetName())] = struct{}{}
+		return nil
+	})
+	return dirs, err
+}
+
+// artifactDirs returns the artifact directories of the given kind in storage, restricted to the Namespace if set.
+func (s *StorageSweeper) artifactDirs(kind string) ([]string, error) {
+	kindDir := strings.ToLower(kind)
+	namespaces, err := os.ReadDir(filepath.Join(s.Storage.BasePath, kindDir))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var dirs []string
+	for _, ns := range namespaces {
+		if !ns.IsDir() || (s.Namespace != "" && ns.Name() != s.Namespace) {
+			continue
+		}
+		names, err := os.ReadDir(filepath.Join(s.Storage.BasePath, kindDir, ns.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if name.IsDir() {
+				dirs = append(dirs, sourcev1.ArtifactDir(kind, ns.Name(), name.Name()))
+			}
+		}
+	}
+	return dirs, nil
+}
+
+// lastModified returns the most recent modification time of the given directory and its direct entries.
+func lastModified(dir string) (time.Time, error) {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	modified := fi.ModTime()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return time.Time{}, err
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+)
+
+func TestStorageSweeper_Sweep(t *testing.T) {
+	dir, err := createStoragePath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(cleanupStoragePath(dir))
+
+	storage, err := NewStorage(dir, "hostname", time.Minute)
+	if err != nil {
+		t.Fatalf("error while bootstrapping storage: %v", err)
+	}
+
+	old := time.Now().Add(-2 * time.Hour)
+	mkArtifactDir := func(kind, namespace, name string, modTime time.Time) string {
+		t.Helper()
+		artifactDir := sourcev1.ArtifactDir(kind, namespace, name)
+		localPath := filepath.Join(dir, filepath.FromSlash(artifactDir))
+		if err := os.MkdirAll(localPath, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		artifact := filepath.Join(localPath, "artifact.tar.gz")
+		if err := os.WriteFile(artifact, []byte("artifact"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range []string{artifact, localPath} {
+			if err := os.Chtimes(p, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return artifactDir
+	}
+	live := mkArtifactDir(sourcev1.GitRepositoryKind, "default", "live", old)
+	orphan := mkArtifactDir(sourcev1.GitRepositoryKind, "default", "orphan", old)
+	recent := mkArtifactDir(sourcev1.BucketKind, "default", "recent", time.Now())
+	otherNamespace := mkArtifactDir(sourcev1.HelmChartKind, "other", "orphan", old)
+
+	scheme := runtime.NewScheme()
+	if err := sourcev1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(&sourcev1.GitRepository{
+		ObjectMeta: metav1.ObjectMeta{Name: "live", Namespace: "default"},
+	}).Build()
+
+	exists := func(artifactDir string) bool {
+		_, err := os.Stat(filepath.Join(dir, filepath.FromSlash(artifactDir)))
+		return err == nil
+	}
+
+	sweeper := &StorageSweeper{
+		Reader:      c,
+		Storage:     storage,
+		Namespace:   "default",
+		GracePeriod: time.Hour,
+		DryRun:      true,
+	}
+	orphans, err := sweeper.Sweep(context.TODO())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if len(orphans) != 1 || orphans[0] != orphan {
+		t.Errorf("Sweep() orphans = %v, want [%s]", orphans, orphan)
+	}
+	if !exists(orphan) {
+		t.Error("Sweep() removed orphan in dry-run mode")
+	}
+
+	sweeper.DryRun = false
+	if _, err := sweeper.Sweep(context.TODO()); err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if exists(orphan) {
+		t.Error("Sweep() did not remove orphan")
+	}
+	for _, d := range []string{live, recent, otherNamespace} {
+		if !exists(d) {
+			t.Errorf("Sweep() removed %s", d)
+		}
+	}
+
+	sweeper.Namespace = ""
+	orphans, err = sweeper.Sweep(context.TODO())
+	if err != nil {
+		t.Fatalf("Sweep() error = %v", err)
+	}
+	if len(orphans) != 1 || orphans[0] != otherNamespace || exists(otherNamespace) {
+		t.Errorf("Sweep() orphans = %v, want [%s] removed", orphans, otherNamespace)
+	}
+	if exists(filepath.Dir(otherNamespace)) {
+		t.Error("Sweep() did not remove empty namespace directory")
+	}
+}
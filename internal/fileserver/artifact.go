@@ -0,0 +1,158 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxDigestCacheEntries is the number of file digests the ArtifactHandler
+// keeps in memory before the cache is reset.
+const maxDigestCacheEntries = 4096
+
+// ArtifactHandler serves the artifacts in a storage directory.
+//
+// The responses carry an ETag and a Digest header derived from the SHA256
+// checksum of the file, which equals the Checksum of the v1beta1.Artifact.
+// Conditional (If-None-Match, If-Modified-Since) and Range requests are
+// supported. Directories are not listed.
+type ArtifactHandler struct {
+	root string
+
+	// Access records the served artifacts, if not nil.
+	Access AccessRecorder
+
+	mu      sync.Mutex
+	digests map[string]digest
+}
+
+// AccessRecorder records the artifacts served by the ArtifactHandler.
+type AccessRecorder interface {
+	// RecordAccess records that the artifact at the given slash separated
+	// path, relative to the root directory, has been served.
+	RecordAccess(path string)
+}
+
+type digest struct {
+	modTime time.Time
+	size    int64
+	sum     []byte
+}
+
+// NewArtifactHandler returns an ArtifactHandler for the given root directory.
+func NewArtifactHandler(root string) *ArtifactHandler {
+	return &ArtifactHandler{
+		root:    root,
+		digests: make(map[string]digest),
+	}
+}
+
+// ServeHTTP implements http.Handler.
+func (h *ArtifactHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	localPath, relPath, err := h.resolve(r.URL.Path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		http.NotFound(w, r)
+		return
+	}
+
+	sum, err := h.digest(localPath, f, fi)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum)+`"`)
+	w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum))
+	if h.Access != nil {
+		h.Access.RecordAccess(relPath)
+	}
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// resolve returns the local path of the file for the given URL path, with
+// all symlinks evaluated, and the slash separated path of the file relative
+// to the root directory. It returns an error if the file does not exist, or
+// if it is outside the root directory.
+func (h *ArtifactHandler) resolve(urlPath string) (string, string, error) {
+	root, err := filepath.EvalSymlinks(h.root)
+	if err != nil {
+		return "", "", err
+	}
+	p, err := filepath.EvalSymlinks(filepath.Join(root, filepath.FromSlash(path.Clean("/"+urlPath))))
+	if err != nil {
+		return "", "", err
+	}
+	prefix := root + string(filepath.Separator)
+	if !strings.HasPrefix(p, prefix) {
+		return "", "", os.ErrNotExist
+	}
+	return p, filepath.ToSlash(strings.TrimPrefix(p, prefix)), nil
+}
+
+// digest returns the SHA256 checksum of the given file, from cache if the
+// file has not changed since it was last calculated.
+func (h *ArtifactHandler) digest(localPath string, f *os.File, fi os.FileInfo) ([]byte, error) {
+	h.mu.Lock()
+	d, ok := h.digests[localPath]
+	h.mu.Unlock()
+	if ok && d.size == fi.Size() && d.modTime.Equal(fi.ModTime()) {
+		return d.sum, nil
+	}
+
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	d = digest{modTime: fi.ModTime(), size: fi.Size(), sum: hasher.Sum(nil)}
+
+	h.mu.Lock()
+	if len(h.digests) >= maxDigestCacheEntries {
+		h.digests = make(map[string]digest)
+	}
+	h.digests[localPath] = d
+	h.mu.Unlock()
+	return d.sum, nil
+}
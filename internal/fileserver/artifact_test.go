@@ -0,0 +1,210 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestArtifactHandler_ServeHTTP(t *testing.T) {
+	g := NewWithT(t)
+
+	root := t.TempDir()
+	content := []byte("artifact content")
+	sum := sha256.Sum256(content)
+	etag := fmt.Sprintf(`"%x"`, sum)
+
+	dir := filepath.Join(root, "gitrepository", "default", "podinfo")
+	g.Expect(os.MkdirAll(dir, 0o755)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(dir, "revision.tar.gz"), content, 0o644)).To(Succeed())
+	g.Expect(os.Symlink(filepath.Join(dir, "revision.tar.gz"), filepath.Join(dir, "latest.tar.gz"))).To(Succeed())
+	g.Expect(os.Symlink("/etc/passwd", filepath.Join(dir, "escape"))).To(Succeed())
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     http.Header
+		wantStatus int
+		wantBody   string
+		wantETag   bool
+	}{
+		{
+			name:       "artifact",
+			path:       "/gitrepository/default/podinfo/revision.tar.gz",
+			wantStatus: http.StatusOK,
+			wantBody:   string(content),
+			wantETag:   true,
+		},
+		{
+			name:       "symlink",
+			path:       "/gitrepository/default/podinfo/latest.tar.gz",
+			wantStatus: http.StatusOK,
+			wantBody:   string(content),
+			wantETag:   true,
+		},
+		{
+			name:       "if-none-match",
+			path:       "/gitrepository/default/podinfo/revision.tar.gz",
+			header:     http.Header{"If-None-Match": []string{etag}},
+			wantStatus: http.StatusNotModified,
+			wantETag:   true,
+		},
+		{
+			name:       "if-none-match mismatch",
+			path:       "/gitrepository/default/podinfo/revision.tar.gz",
+			header:     http.Header{"If-None-Match": []string{`"other"`}},
+			wantStatus: http.StatusOK,
+			wantBody:   string(content),
+			wantETag:   true,
+		},
+		{
+			name:       "range",
+			path:       "/gitrepository/default/podinfo/revision.tar.gz",
+			header:     http.Header{"Range": []string{"bytes=0-7"}},
+			wantStatus: http.StatusPartialContent,
+			wantBody:   string(content[:8]),
+			wantETag:   true,
+		},
+		{
+			name:       "directory",
+			path:       "/gitrepository/default/podinfo/",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "not found",
+			path:       "/gitrepository/default/podinfo/other.tar.gz",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "symlink outside root",
+			path:       "/gitrepository/default/podinfo/escape",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "method not allowed",
+			method:     http.MethodPost,
+			path:       "/gitrepository/default/podinfo/revision.tar.gz",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	h := NewArtifactHandler(root)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			g.Expect(rec.Code).To(Equal(tt.wantStatus))
+			if tt.wantBody != "" {
+				g.Expect(rec.Body.String()).To(Equal(tt.wantBody))
+			}
+			if tt.wantETag {
+				g.Expect(rec.Header().Get("ETag")).To(Equal(etag))
+				g.Expect(rec.Header().Get("Digest")).To(Equal("sha-256=" + base64.StdEncoding.EncodeToString(sum[:])))
+			}
+		})
+	}
+}
+
+func TestArtifactHandler_digestCache(t *testing.T) {
+	g := NewWithT(t)
+
+	root := t.TempDir()
+	p := filepath.Join(root, "artifact.tar.gz")
+	g.Expect(os.WriteFile(p, []byte("first"), 0o644)).To(Succeed())
+
+	h := NewArtifactHandler(root)
+	get := func() string {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/artifact.tar.gz", nil))
+		return rec.Header().Get("ETag")
+	}
+
+	first := get()
+	g.Expect(get()).To(Equal(first))
+
+	g.Expect(os.WriteFile(p, []byte("second content"), 0o644)).To(Succeed())
+	g.Expect(get()).To(Equal(fmt.Sprintf(`"%x"`, sha256.Sum256([]byte("second content")))))
+}
+
+func TestWithInstrumentation(t *testing.T) {
+	g := NewWithT(t)
+
+	metrics := NewMetricsRecorder()
+	h := WithInstrumentation(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte("content"))
+	}), logr.Discard(), metrics)
+
+	for _, p := range []string{"/gitrepository/default/podinfo/latest.tar.gz", "/bucket/default/podinfo/latest.tar.gz", "/missing"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, p, nil))
+	}
+
+	g.Expect(testutil.ToFloat64(metrics.requestsCounter.WithLabelValues("GitRepository", http.MethodGet, "200"))).To(Equal(float64(1)))
+	g.Expect(testutil.ToFloat64(metrics.requestsCounter.WithLabelValues("Bucket", http.MethodGet, "200"))).To(Equal(float64(1)))
+	g.Expect(testutil.ToFloat64(metrics.requestsCounter.WithLabelValues(unknownKind, http.MethodGet, "404"))).To(Equal(float64(1)))
+	g.Expect(testutil.ToFloat64(metrics.bytesCounter.WithLabelValues("GitRepository"))).To(Equal(float64(len("content"))))
+}
+
+type accessRecorder []string
+
+func (r *accessRecorder) RecordAccess(path string) {
+	*r = append(*r, path)
+}
+
+func TestArtifactHandler_Access(t *testing.T) {
+	g := NewWithT(t)
+
+	root := t.TempDir()
+	dir := filepath.Join(root, "gitrepository", "default", "podinfo")
+	g.Expect(os.MkdirAll(dir, 0o755)).To(Succeed())
+	g.Expect(os.WriteFile(filepath.Join(dir, "revision.tar.gz"), []byte("content"), 0o644)).To(Succeed())
+	g.Expect(os.Symlink(filepath.Join(dir, "revision.tar.gz"), filepath.Join(dir, "latest.tar.gz"))).To(Succeed())
+
+	access := &accessRecorder{}
+	h := NewArtifactHandler(root)
+	h.Access = access
+	for _, p := range []string{"/gitrepository/default/podinfo/latest.tar.gz", "/gitrepository/default/podinfo/missing.tar.gz"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, p, nil))
+	}
+	g.Expect([]string(*access)).To(Equal([]string{"gitrepository/default/podinfo/revision.tar.gz"}))
+}
@@ -0,0 +1,182 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DiffPath is the path prefix at which the differences between two artifact
+// revisions of a source are served, followed by the lower case kind,
+// namespace and name of the source.
+const DiffPath = "/diff/"
+
+const (
+	// DiffFromQueryKey is the URL query parameter holding the revision to
+	// compare from.
+	DiffFromQueryKey = "from"
+	// DiffToQueryKey is the URL query parameter holding the revision to
+	// compare to.
+	DiffToQueryKey = "to"
+	// DiffUnifiedQueryKey is the URL query parameter which requests unified
+	// diffs of the changed text files when true.
+	DiffUnifiedQueryKey = "unified"
+	// DiffArtifactQueryKey is the URL query parameter holding a signed URL of
+	// an artifact of the source, which authorizes the request in
+	// AuthModeHMAC.
+	DiffArtifactQueryKey = "artifact"
+)
+
+const (
+	// FileAdded is the status of a file that only exists in the to revision.
+	FileAdded = "added"
+	// FileRemoved is the status of a file that only exists in the from
+	// revision.
+	FileRemoved = "removed"
+	// FileModified is the status of a file of which the content differs
+	// between the revisions.
+	FileModified = "modified"
+)
+
+// ErrRevisionNotFound is returned by a Differ when no artifact of a requested
+// revision is in storage.
+var ErrRevisionNotFound = errors.New("artifact revision not found")
+
+// Differ computes the differences between two artifact revisions of a source.
+type Differ interface {
+	// Diff returns the files that differ between the artifacts of the given
+	// revisions of the source of the given lower case kind, namespace and
+	// name. If unified is true, the unified diffs of the changed text files
+	// are included.
+	Diff(kind, namespace, name, from, to string, unified bool) (*Diff, error)
+}
+
+// Diff is the list of files that differ between two artifact revisions.
+type Diff struct {
+	// From is the revision compared from.
+	From string `json:"from"`
+	// To is the revision compared to.
+	To string `json:"to"`
+	// Files are the files that differ, sorted by path.
+	Files []FileDiff `json:"files"`
+	// Truncated is true if the unified diffs of some of the files were
+	// requested but omitted, as computing them exceeded the budget of the
+	// server.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// FileDiff is a file that differs between two artifact revisions.
+type FileDiff struct {
+	// Path is the slash separated path of the file in the artifacts.
+	Path string `json:"path"`
+	// Status is one of FileAdded, FileRemoved or FileModified.
+	Status string `json:"status"`
+	// Diff is the unified diff of the file, if requested and the file is a
+	// text file.
+	Diff string `json:"diff,omitempty"`
+}
+
+// DiffHandler serves the differences between two artifact revisions of a
+// source as JSON, computed by the Differ.
+type DiffHandler struct {
+	Differ Differ
+}
+
+// ServeHTTP serves GET and HEAD requests for
+// DiffPath<kind>/<namespace>/<name>?from=<revision>&to=<revision>.
+func (h *DiffHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, DiffPath), "/")
+	if len(parts) != 3 || !validSegment(parts[0]) || !validSegment(parts[1]) || !validSegment(parts[2]) {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	from, to := q.Get(DiffFromQueryKey), q.Get(DiffToQueryKey)
+	if from == "" || to == "" {
+		http.Error(w, "the 'from' and 'to' revisions are required", http.StatusBadRequest)
+		return
+	}
+	var unified bool
+	if v := q.Get(DiffUnifiedQueryKey); v != "" {
+		var err error
+		if unified, err = strconv.ParseBool(v); err != nil {
+			http.Error(w, "invalid 'unified' value", http.StatusBadRequest)
+			return
+		}
+	}
+
+	diff, err := h.Differ.Diff(parts[0], parts[1], parts[2], from, to, unified)
+	if err != nil {
+		if errors.Is(err, ErrRevisionNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = json.NewEncoder(w).Encode(diff)
+}
+
+// WithDiffAuth wraps the given http.Handler serving DiffPath with the
+// authorization of requests according to the given Options. As diff URLs are
+// not signed, a request is authorized in AuthModeHMAC by a signed URL of an
+// artifact of the same source in the DiffArtifactQueryKey parameter, which
+// grants access to the compared artifacts. The other modes authorize requests
+// like WithAuth.
+func WithDiffAuth(next http.Handler, opts Options) http.Handler {
+	if opts.Mode != AuthModeHMAC {
+		return WithAuth(next, opts)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		artifact, err := url.Parse(r.URL.Query().Get(DiffArtifactQueryKey))
+		if err != nil || artifact.Path == "" {
+			http.Error(w, ErrSignatureMissing.Error(), http.StatusForbidden)
+			return
+		}
+		if path.Dir(artifact.Path) != "/"+strings.TrimPrefix(r.URL.Path, DiffPath) {
+			http.Error(w, "artifact URL does not belong to the source", http.StatusForbidden)
+			return
+		}
+		if err := opts.Signer.Verify(artifact); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validSegment returns true if the given URL path segment can safely be used
+// as a file path segment.
+func validSegment(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, `/\`)
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+)
+
+type fakeDiffer struct {
+	args []interface{}
+}
+
+func (d *fakeDiffer) Diff(kind, namespace, name, from, to string, unified bool) (*Diff, error) {
+	d.args = []interface{}{kind, namespace, name, from, to, unified}
+	if from == "unknown" {
+		return nil, fmt.Errorf("%w: '%s'", ErrRevisionNotFound, from)
+	}
+	return &Diff{From: from, To: to, Files: []FileDiff{{Path: "file.yaml", Status: FileModified}}}, nil
+}
+
+func TestDiffHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		wantCode int
+		wantArgs []interface{}
+	}{
+		{
+			name:     "diff",
+			target:   "/diff/gitrepository/default/podinfo?from=main/abc&to=main/def",
+			wantCode: http.StatusOK,
+			wantArgs: []interface{}{"gitrepository", "default", "podinfo", "main/abc", "main/def", false},
+		},
+		{
+			name:     "unified diff",
+			target:   "/diff/gitrepository/default/podinfo?from=abc&to=def&unified=true",
+			wantCode: http.StatusOK,
+			wantArgs: []interface{}{"gitrepository", "default", "podinfo", "abc", "def", true},
+		},
+		{
+			name:     "missing revision",
+			target:   "/diff/gitrepository/default/podinfo?from=abc",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "invalid unified",
+			target:   "/diff/gitrepository/default/podinfo?from=abc&to=def&unified=maybe",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "revision not found",
+			target:   "/diff/gitrepository/default/podinfo?from=unknown&to=def",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "invalid path",
+			target:   "/diff/gitrepository/podinfo?from=abc&to=def",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "parent directory",
+			target:   "/diff/gitrepository/default/..?from=abc&to=def",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "method not allowed",
+			method:   http.MethodPost,
+			target:   "/diff/gitrepository/default/podinfo?from=abc&to=def",
+			wantCode: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			differ := &fakeDiffer{}
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			rec := httptest.NewRecorder()
+			(&DiffHandler{Differ: differ}).ServeHTTP(rec, httptest.NewRequest(method, tt.target, nil))
+			g.Expect(rec.Code).To(Equal(tt.wantCode))
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			g.Expect(differ.args).To(Equal(tt.wantArgs))
+			g.Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+			var diff Diff
+			g.Expect(json.Unmarshal(rec.Body.Bytes(), &diff)).To(Succeed())
+			g.Expect(diff.Files).To(Equal([]FileDiff{{Path: "file.yaml", Status: FileModified}}))
+		})
+	}
+}
+
+func TestNewHandler_diff(t *testing.T) {
+	g := NewWithT(t)
+
+	h, err := NewHandler(t.TempDir(), Options{Mode: AuthModeToken, Tokens: []string{"token"}, Differ: &fakeDiffer{},
+		Logger: logr.Discard()})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	target := DiffPath + "gitrepository/default/podinfo?from=abc&to=def"
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	g.Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+}
+
+func TestNewHandler_diffHMAC(t *testing.T) {
+	signer, _ := NewURLSigner([]byte("key"), time.Hour)
+	signedURL := func(p string) string {
+		u := &url.URL{Scheme: "http", Host: "source-controller", Path: p}
+		signer.Sign(u)
+		return u.String()
+	}
+
+	h, err := NewHandler(t.TempDir(), Options{Mode: AuthModeHMAC, Signer: signer, Differ: &fakeDiffer{},
+		Logger: logr.Discard()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		artifact   string
+		wantStatus int
+	}{
+		{
+			name:       "signed artifact URL of the source",
+			artifact:   signedURL("/gitrepository/default/podinfo/latest.tar.gz"),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "without artifact URL",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "unsigned artifact URL",
+			artifact:   "http://source-controller/gitrepository/default/podinfo/latest.tar.gz",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "signed artifact URL of another source",
+			artifact:   signedURL("/gitrepository/default/other/latest.tar.gz"),
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			q := url.Values{}
+			q.Set(DiffFromQueryKey, "abc")
+			q.Set(DiffToQueryKey, "def")
+			if tt.artifact != "" {
+				q.Set(DiffArtifactQueryKey, tt.artifact)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DiffPath+"gitrepository/default/podinfo?"+q.Encode(), nil))
+			g.Expect(rec.Code).To(Equal(tt.wantStatus))
+		})
+	}
+}
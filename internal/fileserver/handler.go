@@ -0,0 +1,197 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-logr/logr"
+)
+
+// AuthMode is the mode used by the file server to authorize requests.
+type AuthMode string
+
+const (
+	// AuthModeNone allows all requests.
+	AuthModeNone AuthMode = "none"
+	// AuthModeHMAC only allows requests for URLs signed by the URLSigner.
+	AuthModeHMAC AuthMode = "hmac"
+	// AuthModeToken only allows requests with a bearer token that matches
+	// one of the configured tokens.
+	AuthModeToken AuthMode = "token"
+	// AuthModeMTLS only allows requests made over a TLS connection with a
+	// verified client certificate.
+	AuthModeMTLS AuthMode = "mtls"
+)
+
+// AuthModes is the list of supported AuthMode values.
+var AuthModes = []AuthMode{AuthModeNone, AuthModeHMAC, AuthModeToken, AuthModeMTLS}
+
+// Options configures the file server.
+type Options struct {
+	// Mode is the AuthMode used to authorize requests.
+	Mode AuthMode
+	// Signer is used to verify the signature of requested URLs in
+	// AuthModeHMAC.
+	Signer *URLSigner
+	// Tokens are the bearer tokens accepted in AuthModeToken.
+	Tokens []string
+	// Logger is used to write the access logs.
+	Logger logr.Logger
+	// Metrics records the served requests, if not nil.
+	Metrics *MetricsRecorder
+	// Access records the served artifacts, if not nil.
+	Access AccessRecorder
+	// PublicKey is the PEM encoded public key of the artifact signer, served
+	// without authorization at PublicKeyPath if not empty.
+	PublicKey []byte
+	// Differ computes the differences between artifact revisions served at
+	// DiffPath, if not nil.
+	Differ Differ
+}
+
+// PublicKeyPath is the path at which the public key of the artifact signer is
+// served.
+const PublicKeyPath = "/public.pem"
+
+// Validate returns an error if the Options are incomplete for the configured
+// Mode.
+func (o Options) Validate() error {
+	switch o.Mode {
+	case AuthModeNone, AuthModeMTLS:
+		return nil
+	case AuthModeHMAC:
+		if o.Signer == nil {
+			return fmt.Errorf("auth mode '%s' requires a URL signer", o.Mode)
+		}
+		return nil
+	case AuthModeToken:
+		if len(o.Tokens) == 0 {
+			return fmt.Errorf("auth mode '%s' requires at least one token", o.Mode)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported auth mode '%s', must be one of %v", o.Mode, AuthModes)
+	}
+}
+
+// NewHandler returns an http.Handler that serves the artifacts in the given
+// root directory to requests authorized according to the given Options.
+func NewHandler(root string, opts Options) (http.Handler, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	artifacts := NewArtifactHandler(root)
+	artifacts.Access = opts.Access
+	var h http.Handler = WithAuth(artifacts, opts)
+	if len(opts.PublicKey) > 0 || opts.Differ != nil {
+		mux := http.NewServeMux()
+		mux.Handle("/", h)
+		if len(opts.PublicKey) > 0 {
+			mux.HandleFunc(PublicKeyPath, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodGet && r.Method != http.MethodHead {
+					w.Header().Set("Allow", "GET, HEAD")
+					http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+					return
+				}
+				w.Header().Set("Content-Type", "application/x-pem-file")
+				_, _ = w.Write(opts.PublicKey)
+			})
+		}
+		if opts.Differ != nil {
+			mux.Handle(DiffPath, WithDiffAuth(&DiffHandler{Differ: opts.Differ}, opts))
+		}
+		h = mux
+	}
+	return WithInstrumentation(h, opts.Logger, opts.Metrics), nil
+}
+
+// WithAuth wraps the given http.Handler with the authorization of requests
+// according to the given Options.
+func WithAuth(next http.Handler, opts Options) http.Handler {
+	switch opts.Mode {
+	case AuthModeHMAC:
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := opts.Signer.Verify(r.URL); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	case AuthModeToken:
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !hasToken(r, opts.Tokens) {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	case AuthModeMTLS:
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+				http.Error(w, "client certificate required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	default:
+		return next
+	}
+}
+
+// LoadTokens reads the bearer tokens from the file at the given path. The
+// file is expected to contain one token per line, empty lines are ignored.
+func LoadTokens(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tokens []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if t := strings.TrimSpace(scanner.Text()); t != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read tokens from '%s': %w", path, err)
+	}
+	return tokens, nil
+}
+
+func hasToken(r *http.Request, tokens []string) bool {
+	auth := r.Header.Get("Authorization")
+	const prefix = "Bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return false
+	}
+	got := []byte(auth[len(prefix):])
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(got, []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
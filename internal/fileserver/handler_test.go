@@ -0,0 +1,186 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestOptions_Validate(t *testing.T) {
+	signer, _ := NewURLSigner([]byte("key"), time.Hour)
+
+	tests := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "none", opts: Options{Mode: AuthModeNone}},
+		{name: "mtls", opts: Options{Mode: AuthModeMTLS}},
+		{name: "hmac", opts: Options{Mode: AuthModeHMAC, Signer: signer}},
+		{name: "hmac without signer", opts: Options{Mode: AuthModeHMAC}, wantErr: true},
+		{name: "token", opts: Options{Mode: AuthModeToken, Tokens: []string{"token"}}},
+		{name: "token without tokens", opts: Options{Mode: AuthModeToken}, wantErr: true},
+		{name: "unsupported", opts: Options{Mode: "basic"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			err := tt.opts.Validate()
+			g.Expect(err != nil).To(Equal(tt.wantErr))
+		})
+	}
+}
+
+func TestWithAuth(t *testing.T) {
+	signer, _ := NewURLSigner([]byte("key"), time.Hour)
+	signed := "http://source-controller/gitrepository/default/podinfo/latest.tar.gz"
+	signedURL, _ := http.NewRequest(http.MethodGet, signed, nil)
+	signer.Sign(signedURL.URL)
+
+	tests := []struct {
+		name       string
+		opts       Options
+		url        string
+		header     http.Header
+		tls        *tls.ConnectionState
+		wantStatus int
+	}{
+		{
+			name:       "none",
+			opts:       Options{Mode: AuthModeNone},
+			url:        signed,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "hmac with signed URL",
+			opts:       Options{Mode: AuthModeHMAC, Signer: signer},
+			url:        signedURL.URL.String(),
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "hmac without signature",
+			opts:       Options{Mode: AuthModeHMAC, Signer: signer},
+			url:        signed,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "token",
+			opts:       Options{Mode: AuthModeToken, Tokens: []string{"old", "new"}},
+			url:        signed,
+			header:     http.Header{"Authorization": []string{"Bearer new"}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "token mismatch",
+			opts:       Options{Mode: AuthModeToken, Tokens: []string{"token"}},
+			url:        signed,
+			header:     http.Header{"Authorization": []string{"Bearer other"}},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "token missing",
+			opts:       Options{Mode: AuthModeToken, Tokens: []string{"token"}},
+			url:        signed,
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "mtls with verified client",
+			opts:       Options{Mode: AuthModeMTLS},
+			url:        signed,
+			tls:        &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "mtls without client certificate",
+			opts:       Options{Mode: AuthModeMTLS},
+			url:        signed,
+			tls:        &tls.ConnectionState{},
+			wantStatus: http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			h := WithAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}), tt.opts)
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			req.TLS = tt.tls
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+			g.Expect(rec.Code).To(Equal(tt.wantStatus))
+		})
+	}
+}
+
+func TestLoadTokens(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tokens")
+	g.Expect(os.WriteFile(path, []byte("first\n\n  second \n"), 0o600)).To(Succeed())
+
+	tokens, err := LoadTokens(path)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(tokens).To(Equal([]string{"first", "second"}))
+
+	_, err = LoadTokens(filepath.Join(dir, "nonexistent"))
+	g.Expect(err).To(HaveOccurred())
+}
+
+func TestNewHandler_publicKey(t *testing.T) {
+	g := NewWithT(t)
+
+	root := t.TempDir()
+	g.Expect(os.WriteFile(filepath.Join(root, "artifact.tar.gz"), []byte("content"), 0o644)).To(Succeed())
+
+	publicKey := []byte("-----BEGIN PUBLIC KEY-----\n-----END PUBLIC KEY-----\n")
+	h, err := NewHandler(root, Options{Mode: AuthModeToken, Tokens: []string{"token"}, PublicKey: publicKey, Logger: logr.Discard()})
+	g.Expect(err).ToNot(HaveOccurred())
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, PublicKeyPath, nil))
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	g.Expect(rec.Body.Bytes()).To(Equal(publicKey))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/artifact.tar.gz", nil))
+	g.Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+
+	h, err = NewHandler(root, Options{Mode: AuthModeNone, Logger: logr.Discard()})
+	g.Expect(err).ToNot(HaveOccurred())
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, PublicKeyPath, nil))
+	g.Expect(rec.Code).To(Equal(http.StatusNotFound))
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+
+	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
+)
+
+// unknownKind is the kind label value of requests for paths that do not
+// belong to a known source kind.
+const unknownKind = "unknown"
+
+// kinds maps the artifact directory names to the source kinds.
+var kinds = map[string]string{
+	strings.ToLower(sourcev1.BucketKind):         sourcev1.BucketKind,
+	strings.ToLower(sourcev1.GitRepositoryKind):  sourcev1.GitRepositoryKind,
+	strings.ToLower(sourcev1.HelmChartKind):      sourcev1.HelmChartKind,
+	strings.ToLower(sourcev1.HelmRepositoryKind): sourcev1.HelmRepositoryKind,
+}
+
+// MetricsRecorder records the requests served by the file server.
+type MetricsRecorder struct {
+	requestsCounter   *prometheus.CounterVec
+	bytesCounter      *prometheus.CounterVec
+	durationHistogram *prometheus.HistogramVec
+}
+
+// NewMetricsRecorder returns a new MetricsRecorder.
+func NewMetricsRecorder() *MetricsRecorder {
+	return &MetricsRecorder{
+		requestsCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotk_artifact_server_requests_total",
+				Help: "The total number of artifact server requests.",
+			},
+			[]string{"kind", "method", "code"},
+		),
+		bytesCounter: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "gotk_artifact_server_response_bytes_total",
+				Help: "The total number of bytes served by the artifact server.",
+			},
+			[]string{"kind"},
+		),
+		durationHistogram: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "gotk_artifact_server_request_duration_seconds",
+				Help:    "The duration in seconds of artifact server requests.",
+				Buckets: prometheus.ExponentialBuckets(0.001, 4, 10),
+			},
+			[]string{"kind"},
+		),
+	}
+}
+
+// Collectors returns the prometheus.Collector values of the MetricsRecorder.
+func (r *MetricsRecorder) Collectors() []prometheus.Collector {
+	return []prometheus.Collector{r.requestsCounter, r.bytesCounter, r.durationHistogram}
+}
+
+// RecordRequest records a request for the given kind.
+func (r *MetricsRecorder) RecordRequest(kind, method string, code int, bytes int64, start time.Time) {
+	r.requestsCounter.WithLabelValues(kind, method, strconv.Itoa(code)).Inc()
+	r.bytesCounter.WithLabelValues(kind).Add(float64(bytes))
+	r.durationHistogram.WithLabelValues(kind).Observe(time.Since(start).Seconds())
+}
+
+// WithInstrumentation wraps the given http.Handler with access logs written
+// to the given logger and metrics recorded by the given MetricsRecorder, if
+// not nil.
+func WithInstrumentation(next http.Handler, log logr.Logger, metrics *MetricsRecorder) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rw := &responseWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rw, r)
+
+		kind := kindForPath(r.URL.Path)
+		log.Info("artifact request served",
+			"kind", kind,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", rw.status,
+			"bytes", rw.bytes,
+			"duration", time.Since(start).String(),
+			"remote", r.RemoteAddr,
+			"userAgent", r.UserAgent())
+		if metrics != nil {
+			metrics.RecordRequest(kind, r.Method, rw.status, rw.bytes, start)
+		}
+	})
+}
+
+// kindForPath returns the source kind for the given artifact URL path, or
+// unknownKind.
+func kindForPath(p string) string {
+	dir := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)[0]
+	if kind, ok := kinds[dir]; ok {
+		return kind
+	}
+	return unknownKind
+}
+
+// responseWriter records the status code and number of bytes written to an
+// http.ResponseWriter.
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
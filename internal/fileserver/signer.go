@@ -0,0 +1,113 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	// ExpiresQueryKey is the URL query parameter holding the Unix timestamp
+	// after which a signed URL is no longer valid.
+	ExpiresQueryKey = "expires"
+	// SignatureQueryKey is the URL query parameter holding the hex encoded
+	// HMAC-SHA256 signature of a signed URL.
+	SignatureQueryKey = "signature"
+)
+
+var (
+	// ErrSignatureMissing is returned when a URL does not carry a signature.
+	ErrSignatureMissing = errors.New("missing URL signature")
+	// ErrSignatureInvalid is returned when the signature of a URL does not
+	// match its path and expiry.
+	ErrSignatureInvalid = errors.New("invalid URL signature")
+	// ErrSignatureExpired is returned when a signed URL has expired.
+	ErrSignatureExpired = errors.New("URL signature has expired")
+)
+
+// URLSigner signs and verifies artifact URLs using HMAC-SHA256, with an
+// expiry time.
+//
+// The expiry of a signature is aligned to a window of the configured TTL,
+// which results in stable URLs for all signatures made within the same
+// window. A signed URL is valid for at least the TTL and at most twice the
+// TTL.
+type URLSigner struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+// NewURLSigner returns a URLSigner for the given key and TTL.
+func NewURLSigner(key []byte, ttl time.Duration) (*URLSigner, error) {
+	if len(key) == 0 {
+		return nil, errors.New("URL signing key can not be empty")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("URL signature TTL must be greater than zero, got %s", ttl)
+	}
+	return &URLSigner{key: key, ttl: ttl, now: time.Now}, nil
+}
+
+// Sign sets the expiry and signature query parameters on the given URL,
+// replacing any existing query.
+func (s *URLSigner) Sign(u *url.URL) {
+	expires := s.now().Truncate(s.ttl).Add(2 * s.ttl).Unix()
+	q := url.Values{}
+	q.Set(ExpiresQueryKey, strconv.FormatInt(expires, 10))
+	q.Set(SignatureQueryKey, s.signature(u.Path, expires))
+	u.RawQuery = q.Encode()
+}
+
+// TTL returns the minimum duration a signed URL is valid for.
+func (s *URLSigner) TTL() time.Duration {
+	return s.ttl
+}
+
+// Verify returns an error if the given URL does not carry a valid signature
+// for its path, or if the signature has expired.
+func (s *URLSigner) Verify(u *url.URL) error {
+	q := u.Query()
+	sig, exp := q.Get(SignatureQueryKey), q.Get(ExpiresQueryKey)
+	if sig == "" || exp == "" {
+		return ErrSignatureMissing
+	}
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrSignatureInvalid
+	}
+	if !hmac.Equal([]byte(sig), []byte(s.signature(u.Path, expires))) {
+		return ErrSignatureInvalid
+	}
+	if s.now().Unix() > expires {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+func (s *URLSigner) signature(path string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%d\n%s", expires, path)
+	return hex.EncodeToString(mac.Sum(nil))
+}
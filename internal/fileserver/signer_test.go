@@ -0,0 +1,130 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+func TestNewURLSigner(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewURLSigner(nil, time.Hour)
+	g.Expect(err).To(HaveOccurred())
+
+	_, err = NewURLSigner([]byte("key"), 0)
+	g.Expect(err).To(HaveOccurred())
+
+	s, err := NewURLSigner([]byte("key"), time.Hour)
+	g.Expect(err).ToNot(HaveOccurred())
+	g.Expect(s).ToNot(BeNil())
+}
+
+func TestURLSigner_Sign(t *testing.T) {
+	g := NewWithT(t)
+
+	now := time.Date(2021, 10, 5, 12, 30, 0, 0, time.UTC)
+	s, err := NewURLSigner([]byte("key"), time.Hour)
+	g.Expect(err).ToNot(HaveOccurred())
+	s.now = func() time.Time { return now }
+
+	u, _ := url.Parse("http://source-controller/gitrepository/default/podinfo/latest.tar.gz?foo=bar")
+	s.Sign(u)
+	g.Expect(u.Query().Get("foo")).To(BeEmpty())
+	g.Expect(u.Query().Get(ExpiresQueryKey)).To(Equal("1633442400"))
+	g.Expect(u.Query().Get(SignatureQueryKey)).ToNot(BeEmpty())
+
+	// Signatures within the same window are stable.
+	s.now = func() time.Time { return now.Add(20 * time.Minute) }
+	u2, _ := url.Parse("http://source-controller/gitrepository/default/podinfo/latest.tar.gz")
+	s.Sign(u2)
+	g.Expect(u2.String()).To(Equal(u.String()))
+}
+
+func TestURLSigner_Verify(t *testing.T) {
+	now := time.Date(2021, 10, 5, 12, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		mutate  func(u *url.URL)
+		at      time.Time
+		wantErr error
+	}{
+		{
+			name: "valid signature",
+			at:   now,
+		},
+		{
+			name: "valid signature within ttl",
+			at:   now.Add(time.Hour),
+		},
+		{
+			name:    "expired signature",
+			at:      now.Add(2 * time.Hour),
+			wantErr: ErrSignatureExpired,
+		},
+		{
+			name:    "missing signature",
+			mutate:  func(u *url.URL) { u.RawQuery = "" },
+			at:      now,
+			wantErr: ErrSignatureMissing,
+		},
+		{
+			name:    "other path",
+			mutate:  func(u *url.URL) { u.Path = "/gitrepository/other/podinfo/latest.tar.gz" },
+			at:      now,
+			wantErr: ErrSignatureInvalid,
+		},
+		{
+			name: "extended expiry",
+			mutate: func(u *url.URL) {
+				q := u.Query()
+				q.Set(ExpiresQueryKey, "1733442400")
+				u.RawQuery = q.Encode()
+			},
+			at:      now,
+			wantErr: ErrSignatureInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			s, err := NewURLSigner([]byte("key"), time.Hour)
+			g.Expect(err).ToNot(HaveOccurred())
+			s.now = func() time.Time { return now }
+
+			u, _ := url.Parse("http://source-controller/gitrepository/default/podinfo/latest.tar.gz")
+			s.Sign(u)
+			if tt.mutate != nil {
+				tt.mutate(u)
+			}
+
+			s.now = func() time.Time { return tt.at }
+			err = s.Verify(u)
+			if tt.wantErr != nil {
+				g.Expect(err).To(MatchError(tt.wantErr))
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+		})
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// DefaultCertificateReloadInterval is the default minimum interval at which a
+// CertificateReloader checks the certificate and key files for changes.
+const DefaultCertificateReloadInterval = 10 * time.Second
+
+// CertificateReloader serves a TLS certificate loaded from a certificate and
+// key file, and reloads it when either file changes. This allows the
+// certificate to be rotated without restarting the file server, for example
+// when it is mounted from a Kubernetes Secret managed by cert-manager.
+//
+// The files are checked for changes during the TLS handshake, at most once
+// per interval. When the changed files can not be loaded, for example because
+// only one of them has been updated yet, the previous certificate is served
+// until the next check.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+	logger   logr.Logger
+
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	certInfo os.FileInfo
+	keyInfo  os.FileInfo
+	checked  time.Time
+
+	now func() time.Time
+}
+
+// NewCertificateReloader returns a CertificateReloader for the given
+// certificate and key file, which are checked for changes at most once per
+// the given interval. It returns an error if the certificate can not be
+// loaded.
+func NewCertificateReloader(certFile, keyFile string, interval time.Duration, logger logr.Logger) (*CertificateReloader, error) {
+	r := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+		logger:   logger,
+		now:      time.Now,
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, reloading it first if the
+// certificate or key file changed. It can be used as
+// tls.Config.GetCertificate.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now := r.now(); now.Sub(r.checked) >= r.interval {
+		r.checked = now
+		if r.changed() {
+			if err := r.load(); err != nil {
+				r.logger.Error(err, "unable to reload TLS certificate, serving previous certificate")
+			} else {
+				r.logger.Info("reloaded TLS certificate", "cert", r.certFile)
+			}
+		}
+	}
+	return r.cert, nil
+}
+
+// changed returns true if the certificate or key file changed since they
+// were last loaded.
+func (r *CertificateReloader) changed() bool {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false
+	}
+	return !sameFile(r.certInfo, certInfo) || !sameFile(r.keyInfo, keyInfo)
+}
+
+// load loads the certificate from the certificate and key file.
+func (r *CertificateReloader) load() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("unable to read TLS certificate: %w", err)
+	}
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to read TLS key: %w", err)
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("unable to load TLS certificate: %w", err)
+	}
+	r.cert, r.certInfo, r.keyInfo = &cert, certInfo, keyInfo
+	return nil
+}
+
+// sameFile returns true if the given os.FileInfo values describe the same,
+// unmodified file.
+func sameFile(a, b os.FileInfo) bool {
+	return a != nil && b != nil && os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
@@ -0,0 +1,120 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fileserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	. "github.com/onsi/gomega"
+)
+
+func TestCertificateReloader(t *testing.T) {
+	g := NewWithT(t)
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	_, err := NewCertificateReloader(certFile, keyFile, time.Minute, logr.Discard())
+	g.Expect(err).To(HaveOccurred())
+
+	writeCertificate(t, certFile, keyFile, "first")
+	r, err := NewCertificateReloader(certFile, keyFile, time.Minute, logr.Discard())
+	g.Expect(err).ToNot(HaveOccurred())
+	now := time.Now()
+	r.now = func() time.Time { return now }
+
+	g.Expect(commonName(t, r)).To(Equal("first"))
+
+	// The files are not checked again within the interval.
+	writeCertificate(t, certFile, keyFile, "second")
+	g.Expect(commonName(t, r)).To(Equal("first"))
+
+	now = now.Add(time.Minute)
+	g.Expect(commonName(t, r)).To(Equal("second"))
+
+	// The previous certificate is served while the files can not be loaded.
+	g.Expect(replaceFile(keyFile, []byte("invalid"))).To(Succeed())
+	now = now.Add(time.Minute)
+	g.Expect(commonName(t, r)).To(Equal("second"))
+
+	writeCertificate(t, certFile, keyFile, "third")
+	now = now.Add(time.Minute)
+	g.Expect(commonName(t, r)).To(Equal("third"))
+}
+
+func commonName(t *testing.T, r *CertificateReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Subject.CommonName
+}
+
+func writeCertificate(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// replaceFile atomically replaces the given file, like the kubelet does for
+// the files of a mounted Secret.
+func replaceFile(name string, data []byte) error {
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package signing signs and verifies artifact digests.
+//
+// Signatures are made over the SHA256 digest of an artifact, and encoded as
+// base64 in detached signature files. For ECDSA P-256 keys the signature is
+// an ASN.1 encoded ECDSA signature of the digest, which can be verified with
+// 'cosign verify-blob --key <public key> --signature <file>.sig <file>'. For
+// ed25519 keys the signature is an ed25519 signature of the digest.
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SignatureExtension is the file name extension of detached signatures.
+const SignatureExtension = ".sig"
+
+// Signer signs artifact digests with a private key.
+type Signer struct {
+	key          crypto.Signer
+	publicKeyPEM []byte
+	keyID        string
+}
+
+// LoadSigner returns a Signer for the PEM encoded private key in the file at
+// the given path. Supported are PKCS #8 encoded ed25519 and ECDSA P-256 keys,
+// and SEC 1 encoded ECDSA P-256 keys.
+func LoadSigner(path string) (*Signer, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in '%s'", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type '%s' in '%s'", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key from '%s': %w", path, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T in '%s'", key, path)
+	}
+	return NewSigner(signer)
+}
+
+// NewSigner returns a Signer for the given ed25519 or ECDSA P-256 private key.
+func NewSigner(key crypto.Signer) (*Signer, error) {
+	switch k := key.(type) {
+	case ed25519.PrivateKey:
+	case *ecdsa.PrivateKey:
+		if k.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("unsupported ECDSA curve '%s', must be P-256", k.Curve.Params().Name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported private key type %T, must be ed25519 or ECDSA P-256", key)
+	}
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	id := sha256.Sum256(der)
+	return &Signer{
+		key:          key,
+		publicKeyPEM: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}),
+		keyID:        hex.EncodeToString(id[:]),
+	}, nil
+}
+
+// KeyID returns the hex encoded SHA256 hash of the DER encoded public key.
+func (s *Signer) KeyID() string {
+	return s.keyID
+}
+
+// PublicKeyPEM returns the PEM encoded public key.
+func (s *Signer) PublicKeyPEM() []byte {
+	return s.publicKeyPEM
+}
+
+// Sign returns the base64 encoded signature of the given SHA256 digest.
+func (s *Signer) Sign(digest []byte) ([]byte, error) {
+	if len(digest) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA256 digest length %d", len(digest))
+	}
+	var sig []byte
+	var err error
+	switch k := s.key.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, digest)
+	case *ecdsa.PrivateKey:
+		sig, err = ecdsa.SignASN1(rand.Reader, k, digest)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sig)), nil
+}
+
+// Verify returns an error if the given base64 encoded signature is not a
+// valid signature of the given SHA256 digest for the given PEM encoded
+// public key.
+func Verify(publicKeyPEM, digest, signature []byte) error {
+	block, _ := pem.Decode(publicKeyPEM)
+	if block == nil {
+		return errors.New("no PEM data found in public key")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse public key: %w", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+	var ok bool
+	switch k := pub.(type) {
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, digest, sig)
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(k, digest, sig)
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+	if !ok {
+		return errors.New("invalid signature")
+	}
+	return nil
+}
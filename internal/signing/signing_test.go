@@ -0,0 +1,100 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package signing
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+)
+
+func writeKey(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), "key.pem")
+	if err := os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestLoadSigner(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	edDER, _ := x509.MarshalPKCS8PrivateKey(edKey)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecPKCS8, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	ecSEC1, _ := x509.MarshalECPrivateKey(ecKey)
+	ec384Key, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	ec384DER, _ := x509.MarshalPKCS8PrivateKey(ec384Key)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	rsaDER, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+
+	tests := []struct {
+		name      string
+		blockType string
+		der       []byte
+		wantErr   bool
+	}{
+		{name: "ed25519", blockType: "PRIVATE KEY", der: edDER},
+		{name: "ECDSA P-256 PKCS #8", blockType: "PRIVATE KEY", der: ecPKCS8},
+		{name: "ECDSA P-256 SEC 1", blockType: "EC PRIVATE KEY", der: ecSEC1},
+		{name: "ECDSA P-384", blockType: "PRIVATE KEY", der: ec384DER, wantErr: true},
+		{name: "RSA", blockType: "PRIVATE KEY", der: rsaDER, wantErr: true},
+		{name: "unsupported block", blockType: "CERTIFICATE", der: edDER, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			s, err := LoadSigner(writeKey(t, tt.blockType, tt.der))
+			if tt.wantErr {
+				g.Expect(err).To(HaveOccurred())
+				return
+			}
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(s.KeyID()).To(HaveLen(64))
+
+			digest := sha256.Sum256([]byte("artifact"))
+			sig, err := s.Sign(digest[:])
+			g.Expect(err).ToNot(HaveOccurred())
+			g.Expect(Verify(s.PublicKeyPEM(), digest[:], sig)).To(Succeed())
+
+			other := sha256.Sum256([]byte("tampered"))
+			g.Expect(Verify(s.PublicKeyPEM(), other[:], sig)).ToNot(Succeed())
+		})
+	}
+}
+
+func TestSigner_Sign(t *testing.T) {
+	g := NewWithT(t)
+
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	s, err := NewSigner(key)
+	g.Expect(err).ToNot(HaveOccurred())
+
+	_, err = s.Sign([]byte("not a digest"))
+	g.Expect(err).To(HaveOccurred())
+}
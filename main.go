@@ -17,6 +17,10 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
@@ -28,6 +32,8 @@ import (
 	"github.com/go-logr/logr"
 	flag "github.com/spf13/pflag"
 	"helm.sh/helm/v3/pkg/getter"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -45,12 +51,17 @@ import (
 
 	sourcev1 "github.com/fluxcd/source-controller/api/v1beta1"
 	"github.com/fluxcd/source-controller/controllers"
+	"github.com/fluxcd/source-controller/internal/fileserver"
 	"github.com/fluxcd/source-controller/internal/helm"
+	"github.com/fluxcd/source-controller/internal/signing"
 	// +kubebuilder:scaffold:imports
 )
 
 const controllerName = "source-controller"
 
+// VERSION is the version of the controller, set at build time with -ldflags.
+var VERSION = "0.0.0-dev.0"
+
 var (
 	scheme   = runtime.NewScheme()
 	setupLog = ctrl.Log.WithName("setup")
@@ -70,6 +81,10 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 && (os.Args[1] == exportCommand || os.Args[1] == importCommand) {
+		os.Exit(runMigrationCommand(os.Args[1], os.Args[2:]))
+	}
+
 	var (
 		metricsAddr           string
 		eventsAddr            string
@@ -77,12 +92,37 @@ func main() {
 		storagePath           string
 		storageAddr           string
 		storageAdvAddr        string
+		storageAdvScheme      string
 		concurrent            int
 		requeueDependency     time.Duration
 		watchAllNamespaces    bool
 		helmIndexLimit        int64
 		helmChartLimit        int64
 		helmChartFileLimit    int64
+		artifactRetention     int
+		artifactRetentionAge  time.Duration
+		deterministicArchive  bool
+		embedMetadata         bool
+		storageAuthMode       string
+		storageHMACKeyFile    string
+		storageURLTTL         time.Duration
+		storageTokenFile      string
+		storageTLSCertFile    string
+		storageTLSKeyFile     string
+		storageTLSClientCA    string
+		artifactSigningKey    string
+		storageQuota          string
+		sweepInterval         time.Duration
+		sweepGracePeriod      time.Duration
+		sweepDryRun           bool
+		scrubInterval         time.Duration
+		artifactMaxSize       int64
+		artifactMaxFiles      int64
+		artifactMaxFileSize   int64
+		bucketCachePath       string
+		bucketDownloads       int
+		stsTokenFile          string
+		stsRoles              []string
 		clientOptions         client.Options
 		logOptions            logger.Options
 		leaderElectionOptions leaderelection.Options
@@ -99,6 +139,57 @@ func main() {
 		"The address the static file server binds to.")
 	flag.StringVar(&storageAdvAddr, "storage-adv-addr", envOrDefault("STORAGE_ADV_ADDR", ""),
 		"The advertised address of the static file server.")
+	flag.StringVar(&storageAdvScheme, "storage-adv-scheme", envOrDefault("STORAGE_ADV_SCHEME", ""),
+		"The advertised scheme of the static file server, one of http or https. Defaults to https when TLS is configured, http otherwise.")
+	flag.BoolVar(&deterministicArchive, "storage-deterministic-archive", false,
+		"Archive sources as byte-identical tarballs for identical trees, preserving in-tree symlinks and the executable bit of files.")
+	flag.StringVar(&storageAuthMode, "storage-auth-mode", envOrDefault("STORAGE_AUTH_MODE", string(fileserver.AuthModeNone)),
+		fmt.Sprintf("The mode used by the static file server to authorize requests, one of %v.", fileserver.AuthModes))
+	flag.StringVar(&storageHMACKeyFile, "storage-hmac-key-file", envOrDefault("STORAGE_HMAC_KEY_FILE", ""),
+		"The path to the file containing the key used to sign artifact URLs in 'hmac' auth mode.")
+	flag.DurationVar(&storageURLTTL, "storage-url-ttl", 24*time.Hour,
+		"The minimum duration a signed artifact URL is valid for in 'hmac' auth mode. Sources with a longer interval are reconciled at this interval to sign their URLs again.")
+	flag.StringVar(&storageTokenFile, "storage-token-file", envOrDefault("STORAGE_TOKEN_FILE", ""),
+		"The path to the file containing the bearer tokens accepted in 'token' auth mode, one per line.")
+	flag.StringVar(&storageTLSCertFile, "storage-tls-cert-file", envOrDefault("STORAGE_TLS_CERT_FILE", ""),
+		"The path to the TLS certificate the static file server is served with over HTTPS, reloaded on change. Required in 'mtls' auth mode.")
+	flag.StringVar(&storageTLSKeyFile, "storage-tls-key-file", envOrDefault("STORAGE_TLS_KEY_FILE", ""),
+		"The path to the TLS private key the static file server is served with over HTTPS, reloaded on change. Required in 'mtls' auth mode.")
+	flag.StringVar(&storageTLSClientCA, "storage-tls-client-ca-file", envOrDefault("STORAGE_TLS_CLIENT_CA_FILE", ""),
+		"The path to the CA bundle used to verify client certificates in 'mtls' auth mode.")
+	flag.StringVar(&storageQuota, "storage-quota", envOrDefault("STORAGE_QUOTA", ""),
+		"The maximum size of the artifacts in storage (e.g. 10Gi), least recently served artifacts that are not in use are evicted to stay within it. Unlimited if not set.")
+	flag.DurationVar(&sweepInterval, "storage-sweep-interval", 0,
+		"The interval at which artifacts of sources that no longer exist are removed from storage, disabled if zero.")
+	flag.DurationVar(&sweepGracePeriod, "storage-sweep-grace-period", time.Hour,
+		"The minimum duration the artifacts of a source that no longer exists have not been modified before they are removed.")
+	flag.BoolVar(&sweepDryRun, "storage-sweep-dry-run", false,
+		"Log the artifacts of sources that no longer exist instead of removing them from storage.")
+	flag.DurationVar(&scrubInterval, "storage-scrub-interval", 24*time.Hour,
+		"The interval at which the checksums of the artifacts in storage are verified, disabled if zero.")
+	flag.Int64Var(&artifactMaxSize, "artifact-max-size", 0,
+		"The max allowed total size in bytes of the files in a Git or Bucket artifact, unlimited if zero.")
+	flag.Int64Var(&artifactMaxFiles, "artifact-max-files", 0,
+		"The max allowed number of files in a Git or Bucket artifact, unlimited if zero.")
+	flag.Int64Var(&artifactMaxFileSize, "artifact-max-file-size", 0,
+		"The max allowed size in bytes of a single file in a Git or Bucket artifact, unlimited if zero.")
+	flag.StringVar(&bucketCachePath, "bucket-cache-path", "",
+		"The path at which the objects of Buckets are cached between reconciliations, so that only changed objects are "+
+			"downloaded. Objects are downloaded on every reconciliation if empty. The cache is not counted against "+
+			"the --storage-quota.")
+	flag.IntVar(&bucketDownloads, "bucket-download-concurrency", 10,
+		"The number of objects of a Bucket that are downloaded concurrently.")
+	flag.StringVar(&stsTokenFile, "sts-web-identity-token-file", "",
+		"The path to the web identity token file with which Buckets can assume roles with AssumeRoleWithWebIdentity, "+
+			"e.g. a projected service account token. Buckets can not use web identities if empty.")
+	flag.StringSliceVar(&stsRoles, "sts-web-identity-allowed-roles", nil,
+		"The roles Buckets are allowed to assume with the web identity token file, as '<namespace>=<role ARN>' "+
+			"entries, of which the namespace '*' matches any namespace. Buckets can not use web identities for "+
+			"other roles.")
+	flag.BoolVar(&embedMetadata, "artifact-embed-metadata", false,
+		fmt.Sprintf("Embed the metadata document of Git and Bucket artifacts in the archive at '%s'.", controllers.EmbeddedMetadataPath))
+	flag.StringVar(&artifactSigningKey, "artifact-signing-key-file", envOrDefault("ARTIFACT_SIGNING_KEY_FILE", ""),
+		"The path to the PEM encoded ed25519 or ECDSA P-256 private key used to sign artifacts.")
 	flag.IntVar(&concurrent, "concurrent", 2, "The number of concurrent reconciles per controller.")
 	flag.BoolVar(&watchAllNamespaces, "watch-all-namespaces", true,
 		"Watch for custom resources in all namespaces, if set to false it will only watch the runtime namespace.")
@@ -110,6 +201,10 @@ func main() {
 		"The max allowed size in bytes of a file in a Helm chart.")
 	flag.DurationVar(&requeueDependency, "requeue-dependency", 30*time.Second,
 		"The interval at which failing dependencies are reevaluated.")
+	flag.IntVar(&artifactRetention, "artifact-retention-records", 0,
+		"The number of previous artifacts to retain in storage for sources that do not define their own retention policy.")
+	flag.DurationVar(&artifactRetentionAge, "artifact-retention-min-age", 0,
+		"The minimum duration a superseded artifact is retained in storage for sources that do not define their own retention policy.")
 
 	clientOptions.BindFlags(flag.CommandLine)
 	logOptions.BindFlags(flag.CommandLine)
@@ -167,6 +262,42 @@ func main() {
 		storageAdvAddr = determineAdvStorageAddr(storageAddr, setupLog)
 	}
 	storage := mustInitStorage(storagePath, storageAdvAddr, setupLog)
+	fileServerOpts := mustInitFileServerOptions(fileserver.AuthMode(storageAuthMode), storageHMACKeyFile, storageURLTTL,
+		storageTokenFile, setupLog)
+	fileServerOpts.Logger = ctrl.Log.WithName("file-server")
+	fileServerOpts.Metrics = fileserver.NewMetricsRecorder()
+	crtlmetrics.Registry.MustRegister(fileServerOpts.Metrics.Collectors()...)
+	storage.URLSigner = fileServerOpts.Signer
+	storage.Quota = mustParseStorageQuota(storageQuota, setupLog)
+	storage.Metrics = controllers.NewStorageMetricsRecorder()
+	storage.Metrics.RecordQuota(storage.Quota)
+	storage.InUse = controllers.SourceArtifactLister{Reader: mgr.GetClient(), Namespace: watchNamespace}
+	crtlmetrics.Registry.MustRegister(storage.Metrics.Collectors()...)
+	fileServerOpts.Access = storage
+	fileServerOpts.Differ = storage
+	fileServerTLS := mustInitFileServerTLS(fileServerOpts.Mode, storageTLSCertFile, storageTLSKeyFile, storageTLSClientCA, setupLog)
+	storage.Scheme = mustDetermineAdvStorageScheme(storageAdvScheme, fileServerTLS != nil, setupLog)
+	if artifactSigningKey != "" {
+		signer, err := signing.LoadSigner(artifactSigningKey)
+		if err != nil {
+			setupLog.Error(err, "unable to load artifact signing key")
+			os.Exit(1)
+		}
+		storage.Signer = signer
+		fileServerOpts.PublicKey = signer.PublicKeyPEM()
+	}
+	storage.DeterministicArchive = deterministicArchive
+	storage.ControllerVersion = VERSION
+	storage.EmbedMetadata = embedMetadata
+	storage.ArtifactLimits = sourcev1.ArtifactLimits{
+		MaxSize:     artifactMaxSize,
+		MaxFiles:    artifactMaxFiles,
+		MaxFileSize: artifactMaxFileSize,
+	}
+	storage.ArtifactRetention = sourcev1.ArtifactRetention{
+		Records: artifactRetention,
+		MinAge:  &metav1.Duration{Duration: artifactRetentionAge},
+	}
 
 	if err = (&controllers.GitRepositoryReconciler{
 		Client:                mgr.GetClient(),
@@ -211,18 +342,47 @@ func main() {
 		os.Exit(1)
 	}
 	if err = (&controllers.BucketReconciler{
-		Client:                mgr.GetClient(),
-		Scheme:                mgr.GetScheme(),
-		Storage:               storage,
-		EventRecorder:         mgr.GetEventRecorderFor(controllerName),
-		ExternalEventRecorder: eventRecorder,
-		MetricsRecorder:       metricsRecorder,
+		Client:                  mgr.GetClient(),
+		Scheme:                  mgr.GetScheme(),
+		Storage:                 storage,
+		EventRecorder:           mgr.GetEventRecorderFor(controllerName),
+		ExternalEventRecorder:   eventRecorder,
+		MetricsRecorder:         metricsRecorder,
+		CachePath:               bucketCachePath,
+		DownloadConcurrency:     bucketDownloads,
+		STSWebIdentityTokenFile: stsTokenFile,
+		STSWebIdentityRoles:     mustParseSTSWebIdentityRoles(stsRoles, setupLog),
 	}).SetupWithManagerAndOptions(mgr, controllers.BucketReconcilerOptions{
 		MaxConcurrentReconciles: concurrent,
 	}); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Bucket")
 		os.Exit(1)
 	}
+	if sweepInterval > 0 {
+		if err = mgr.Add(&controllers.StorageSweeper{
+			Reader:      mgr.GetClient(),
+			Storage:     storage,
+			Namespace:   watchNamespace,
+			Interval:    sweepInterval,
+			GracePeriod: sweepGracePeriod,
+			DryRun:      sweepDryRun,
+		}); err != nil {
+			setupLog.Error(err, "unable to add storage sweeper")
+			os.Exit(1)
+		}
+	}
+	if scrubInterval > 0 {
+		if err = mgr.Add(&controllers.StorageScrubber{
+			Client:    mgr.GetClient(),
+			APIReader: mgr.GetAPIReader(),
+			Storage:   storage,
+			Namespace: watchNamespace,
+			Interval:  scrubInterval,
+		}); err != nil {
+			setupLog.Error(err, "unable to add storage scrubber")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	go func() {
@@ -231,7 +391,7 @@ func main() {
 		// to handle that.
 		<-mgr.Elected()
 
-		startFileServer(storage.BasePath, storageAddr, setupLog)
+		startFileServer(storage.BasePath, storageAddr, fileServerOpts, fileServerTLS, setupLog)
 	}()
 
 	setupLog.Info("starting manager")
@@ -241,16 +401,137 @@ func main() {
 	}
 }
 
-func startFileServer(path string, address string, l logr.Logger) {
-	l.Info("starting file server")
-	fs := http.FileServer(http.Dir(path))
+func startFileServer(path string, address string, opts fileserver.Options, tlsConfig *tls.Config, l logr.Logger) {
+	l.Info("starting file server", "auth", opts.Mode, "tls", tlsConfig != nil)
+	fs, err := fileserver.NewHandler(path, opts)
+	if err != nil {
+		l.Error(err, "file server error")
+		return
+	}
 	http.Handle("/", fs)
-	err := http.ListenAndServe(address, nil)
+	if tlsConfig != nil {
+		srv := &http.Server{Addr: address, TLSConfig: tlsConfig}
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = http.ListenAndServe(address, nil)
+	}
 	if err != nil {
 		l.Error(err, "file server error")
 	}
 }
 
+func mustParseStorageQuota(quota string, l logr.Logger) int64 {
+	if quota == "" {
+		return 0
+	}
+	q, err := resource.ParseQuantity(quota)
+	if err != nil {
+		l.Error(err, "invalid storage quota")
+		os.Exit(1)
+	}
+	return q.Value()
+}
+
+func mustParseSTSWebIdentityRoles(entries []string, l logr.Logger) map[string][]string {
+	roles := make(map[string][]string)
+	for _, entry := range entries {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			l.Error(fmt.Errorf("invalid entry '%s', must be of the form '<namespace>=<role ARN>'", entry),
+				"invalid STS web identity roles")
+			os.Exit(1)
+		}
+		roles[parts[0]] = append(roles[parts[0]], parts[1])
+	}
+	return roles
+}
+
+func mustInitFileServerOptions(mode fileserver.AuthMode, hmacKeyFile string, urlTTL time.Duration, tokenFile string,
+	l logr.Logger) fileserver.Options {
+	opts := fileserver.Options{Mode: mode}
+	switch mode {
+	case fileserver.AuthModeHMAC:
+		key, err := os.ReadFile(hmacKeyFile)
+		if err != nil {
+			l.Error(err, "unable to read URL signing key")
+			os.Exit(1)
+		}
+		if opts.Signer, err = fileserver.NewURLSigner(bytes.TrimSpace(key), urlTTL); err != nil {
+			l.Error(err, "unable to initialise URL signer")
+			os.Exit(1)
+		}
+	case fileserver.AuthModeToken:
+		tokens, err := fileserver.LoadTokens(tokenFile)
+		if err != nil {
+			l.Error(err, "unable to read file server tokens")
+			os.Exit(1)
+		}
+		opts.Tokens = tokens
+	}
+	if err := opts.Validate(); err != nil {
+		l.Error(err, "invalid file server options")
+		os.Exit(1)
+	}
+	return opts
+}
+
+func mustInitFileServerTLS(mode fileserver.AuthMode, certFile, keyFile, clientCAFile string, l logr.Logger) *tls.Config {
+	if certFile == "" && keyFile == "" {
+		if mode == fileserver.AuthModeMTLS {
+			l.Error(fmt.Errorf("a TLS certificate and key are required in '%s' auth mode", mode), "invalid file server options")
+			os.Exit(1)
+		}
+		return nil
+	}
+	if certFile == "" || keyFile == "" {
+		l.Error(errors.New("both a TLS certificate and key must be configured"), "invalid file server options")
+		os.Exit(1)
+	}
+	reloader, err := fileserver.NewCertificateReloader(certFile, keyFile, fileserver.DefaultCertificateReloadInterval,
+		ctrl.Log.WithName("file-server"))
+	if err != nil {
+		l.Error(err, "unable to load file server TLS certificate")
+		os.Exit(1)
+	}
+	tlsConfig := &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if mode != fileserver.AuthModeMTLS {
+		return tlsConfig
+	}
+
+	caPEM, err := os.ReadFile(clientCAFile)
+	if err != nil {
+		l.Error(err, "unable to read file server client CA")
+		os.Exit(1)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caPEM) {
+		l.Error(fmt.Errorf("no certificates found in '%s'", clientCAFile), "unable to load file server client CA")
+		os.Exit(1)
+	}
+	tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	tlsConfig.ClientCAs = clientCAs
+	return tlsConfig
+}
+
+func mustDetermineAdvStorageScheme(scheme string, tlsEnabled bool, l logr.Logger) string {
+	switch scheme {
+	case "":
+		if tlsEnabled {
+			return "https"
+		}
+		return "http"
+	case "http", "https":
+		return scheme
+	default:
+		l.Error(fmt.Errorf("unsupported scheme '%s', must be one of http or https", scheme), "invalid storage advertised scheme")
+		os.Exit(1)
+		return ""
+	}
+}
+
 func mustInitStorage(path string, storageAdvAddr string, l logr.Logger) *controllers.Storage {
 	if path == "" {
 		p, _ := os.Getwd()
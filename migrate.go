@@ -0,0 +1,156 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	flag "github.com/spf13/pflag"
+	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlclient "sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/fluxcd/pkg/runtime/client"
+	"github.com/fluxcd/pkg/runtime/logger"
+
+	"github.com/fluxcd/source-controller/controllers"
+	"github.com/fluxcd/source-controller/internal/fileserver"
+)
+
+const (
+	exportCommand = "export"
+	importCommand = "import"
+)
+
+// runMigrationCommand runs the export or import subcommand with the given arguments, and returns the exit code.
+//
+// The export subcommand writes the artifacts in storage, together with the status of their sources, to a bundle. The
+// import subcommand extracts a bundle into a new storage path, and restores the status of the sources that have not
+// been reconciled yet, with the artifact URLs rewritten to the advertised address of the new storage, and signed
+// with the same key as the controller in 'hmac' auth mode.
+func runMigrationCommand(command string, args []string) int {
+	var (
+		bundlePath       string
+		storagePath      string
+		storageAddr      string
+		storageAdvAddr   string
+		storageAdvScheme string
+		storageAuthMode  string
+		storageHMACKey   string
+		storageURLTTL    time.Duration
+		storageTokenFile string
+		namespace        string
+		clientOptions    client.Options
+		logOptions       logger.Options
+	)
+
+	flags := flag.NewFlagSet(command, flag.ContinueOnError)
+	flags.StringVar(&bundlePath, "bundle", "",
+		fmt.Sprintf("The path of the bundle file to %s, or - for standard %s.", command,
+			map[string]string{exportCommand: "output", importCommand: "input"}[command]))
+	flags.StringVar(&storagePath, "storage-path", envOrDefault("STORAGE_PATH", ""),
+		"The local storage path.")
+	switch command {
+	case exportCommand:
+		flags.StringVar(&namespace, "namespace", "",
+			"Only export the artifacts of sources in the given namespace, all namespaces if not set.")
+	case importCommand:
+		flags.StringVar(&storageAddr, "storage-addr", envOrDefault("STORAGE_ADDR", ":9090"),
+			"The address the static file server binds to, used to determine the advertised address if not set.")
+		flags.StringVar(&storageAdvAddr, "storage-adv-addr", envOrDefault("STORAGE_ADV_ADDR", ""),
+			"The advertised address of the static file server the artifact URLs are rewritten to.")
+		flags.StringVar(&storageAdvScheme, "storage-adv-scheme", envOrDefault("STORAGE_ADV_SCHEME", "http"),
+			"The advertised scheme of the static file server the artifact URLs are rewritten to, one of http or https.")
+		flags.StringVar(&storageAuthMode, "storage-auth-mode", envOrDefault("STORAGE_AUTH_MODE", string(fileserver.AuthModeNone)),
+			fmt.Sprintf("The mode used by the static file server to authorize requests, one of %v.", fileserver.AuthModes))
+		flags.StringVar(&storageHMACKey, "storage-hmac-key-file", envOrDefault("STORAGE_HMAC_KEY_FILE", ""),
+			"The path to the file containing the key used to sign the rewritten artifact URLs in 'hmac' auth mode.")
+		flags.DurationVar(&storageURLTTL, "storage-url-ttl", 24*time.Hour,
+			"The minimum duration a signed artifact URL is valid for in 'hmac' auth mode.")
+		flags.StringVar(&storageTokenFile, "storage-token-file", envOrDefault("STORAGE_TOKEN_FILE", ""),
+			"The path to the file containing the bearer tokens accepted in 'token' auth mode, one per line.")
+	}
+	clientOptions.BindFlags(flags)
+	logOptions.BindFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	ctrl.SetLogger(logger.NewLogger(logOptions))
+	log := ctrl.Log.WithName(command)
+	if bundlePath == "" {
+		log.Error(errors.New("--bundle is required"), "invalid arguments")
+		return 2
+	}
+
+	if storageAdvAddr == "" && command == importCommand {
+		storageAdvAddr = determineAdvStorageAddr(storageAddr, log)
+	}
+	storage := mustInitStorage(storagePath, storageAdvAddr, log)
+	if command == importCommand {
+		storage.Scheme = mustDetermineAdvStorageScheme(storageAdvScheme, false, log)
+		storage.URLSigner = mustInitFileServerOptions(fileserver.AuthMode(storageAuthMode), storageHMACKey, storageURLTTL,
+			storageTokenFile, log).Signer
+	}
+
+	c, err := ctrlclient.New(client.GetConfigOrDie(clientOptions), ctrlclient.Options{Scheme: scheme})
+	if err != nil {
+		log.Error(err, "unable to create client")
+		return 1
+	}
+	ctx := ctrl.LoggerInto(context.Background(), log)
+
+	var result controllers.BundleResult
+	switch command {
+	case exportCommand:
+		var w io.WriteCloser = os.Stdout
+		if bundlePath != "-" {
+			if w, err = os.Create(bundlePath); err != nil {
+				log.Error(err, "unable to create bundle")
+				return 1
+			}
+		}
+		result, err = storage.ExportBundle(ctx, c, namespace, w)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	case importCommand:
+		var r io.ReadCloser = os.Stdin
+		if bundlePath != "-" {
+			if r, err = os.Open(bundlePath); err != nil {
+				log.Error(err, "unable to open bundle")
+				return 1
+			}
+		}
+		result, err = storage.ImportBundle(ctx, c, r)
+		r.Close()
+	}
+	if err != nil {
+		log.Error(err, fmt.Sprintf("%s failed", command))
+		return 1
+	}
+	log.Info(fmt.Sprintf("%s completed", command),
+		"sources", result.Sources, "files", result.Files, "statuses", result.Statuses)
+	return 0
+}
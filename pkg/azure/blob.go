@@ -0,0 +1,352 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+
+	"github.com/fluxcd/source-controller/pkg/objectstore"
+)
+
+const (
+	// AccountNameField is the secret field holding the storage account name. It is optional for endpoints of the form
+	// <account>.blob.core.windows.net, of which the first label is used.
+	AccountNameField = "accountName"
+	// AccountKeyField is the secret field holding the shared key of the storage account.
+	AccountKeyField = "accountKey"
+	// SASTokenField is the secret field holding a shared access signature token.
+	SASTokenField = "sasToken"
+	// TenantIDField is the secret field holding the tenant ID of a service principal.
+	TenantIDField = "tenantId"
+	// ClientIDField is the secret field holding the client ID of a service principal.
+	ClientIDField = "clientId"
+	// ClientSecretField is the secret field holding the client secret of a service principal.
+	ClientSecretField = "clientSecret"
+	// AuthorityHostField is the secret field holding the Azure Active Directory authority host a service principal
+	// authenticates with. It defaults to DefaultAuthorityHost.
+	AuthorityHostField = "authorityHost"
+
+	// DefaultAuthorityHost is the authority host of the Azure public cloud.
+	DefaultAuthorityHost = "https://login.microsoftonline.com/"
+
+	// storageScope is the OAuth2 scope of Azure Storage.
+	storageScope = "https://storage.azure.com/.default"
+	// tokenRefreshMargin is the time before expiry at which a service principal token is refreshed.
+	tokenRefreshMargin = 2 * time.Minute
+)
+
+var (
+	// tokenRetryInterval is the interval at which a failed service principal token refresh is retried, and the
+	// minimum interval between refreshes.
+	tokenRetryInterval = 30 * time.Second
+
+	// ErrorDirectoryExists is an error returned when the filename provided
+	// is a directory.
+	ErrorDirectoryExists = errors.New("filename is a directory")
+	// ErrorObjectDoesNotExist is an error returned when the object whose name
+	// is provided does not exist.
+	ErrorObjectDoesNotExist = errors.New("object does not exist")
+)
+
+// BlobClient is a client for an Azure Blob Storage account.
+type BlobClient struct {
+	// ServiceURL is the URL of the storage account, with the pipeline
+	// of the configured credential.
+	azblob.ServiceURL
+
+	// stopRefresh stops the refresh of the service principal token, if
+	// the client authenticates with a service principal.
+	stopRefresh context.CancelFunc
+}
+
+// NewClient creates a new Azure Blob Storage client for the given service URL, e.g.
+// https://<account>.blob.core.windows.net or http://127.0.0.1:10000/devstoreaccount1 for the Azurite emulator.
+// The client authenticates with the credentials in the given secret data, which takes precedence in the order shared
+// key, service principal and SAS token. Without secret data, the client can only access public containers.
+func NewClient(ctx context.Context, serviceURL string, secret map[string][]byte) (*BlobClient, error) {
+	u, err := url.Parse(serviceURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service URL '%s': %w", serviceURL, err)
+	}
+
+	var (
+		credential  azblob.Credential
+		stopRefresh context.CancelFunc
+	)
+	switch {
+	case hasField(secret, AccountKeyField):
+		accountName := string(secret[AccountNameField])
+		if accountName == "" {
+			accountName = accountNameFromURL(u)
+		}
+		if accountName == "" {
+			return nil, fmt.Errorf("unable to determine account name of '%s', '%s' is required", serviceURL, AccountNameField)
+		}
+		if credential, err = azblob.NewSharedKeyCredential(accountName, string(secret[AccountKeyField])); err != nil {
+			return nil, fmt.Errorf("invalid shared key: %w", err)
+		}
+	case hasField(secret, ClientIDField):
+		if credential, stopRefresh, err = newServicePrincipalCredential(ctx, http.DefaultClient, secret); err != nil {
+			return nil, err
+		}
+	case hasField(secret, SASTokenField):
+		u.RawQuery = strings.TrimPrefix(string(secret[SASTokenField]), "?")
+		credential = azblob.NewAnonymousCredential()
+	default:
+		credential = azblob.NewAnonymousCredential()
+	}
+
+	p := azblob.NewPipeline(credential, azblob.PipelineOptions{})
+	return &BlobClient{ServiceURL: azblob.NewServiceURL(*u, p), stopRefresh: stopRefresh}, nil
+}
+
+// ValidateSecret validates the credential secrets
+// It ensures that needed secret fields are not missing.
+func ValidateSecret(secret map[string][]byte, name string) error {
+	switch {
+	case hasField(secret, AccountKeyField), hasField(secret, SASTokenField):
+		return nil
+	case hasField(secret, TenantIDField) || hasField(secret, ClientIDField) || hasField(secret, ClientSecretField):
+		if !hasField(secret, TenantIDField) || !hasField(secret, ClientIDField) || !hasField(secret, ClientSecretField) {
+			return fmt.Errorf("invalid '%s' secret data: required fields '%s', '%s' and '%s'",
+				name, TenantIDField, ClientIDField, ClientSecretField)
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid '%s' secret data: required fields '%s', '%s', or '%s', '%s' and '%s'",
+		name, AccountKeyField, SASTokenField, TenantIDField, ClientIDField, ClientSecretField)
+}
+
+// BucketExists checks if the container with the provided name exists.
+func (c *BlobClient) BucketExists(ctx context.Context, containerName string) (bool, error) {
+	_, err := c.NewContainerURL(containerName).GetProperties(ctx, azblob.LeaseAccessConditions{})
+	if err != nil {
+		if hasServiceCode(err, azblob.ServiceCodeContainerNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// FGetObject gets the blob from the container and downloads the object locally.
+// It returns ErrorObjectDoesNotExist if the blob does not exist.
+func (c *BlobClient) FGetObject(ctx context.Context, containerName, objectName, localPath string) error {
+	// Verify if destination already exists.
+	if fi, err := os.Stat(localPath); err == nil && fi.IsDir() {
+		return ErrorDirectoryExists
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	blobURL := c.NewContainerURL(containerName).NewBlobURL(objectName)
+	// Request the blob before creating the file, so that no file is left behind for a missing blob.
+	resp, err := blobURL.Download(ctx, 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false,
+		azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		if hasServiceCode(err, azblob.ServiceCodeBlobNotFound) {
+			return ErrorObjectDoesNotExist
+		}
+		return err
+	}
+	body := resp.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3})
+	defer body.Close()
+
+	// Create any missing top level directories.
+	if objectDir := filepath.Dir(localPath); objectDir != "" {
+		if err := os.MkdirAll(objectDir, 0700); err != nil {
+			return err
+		}
+	}
+	objectFile, err := os.OpenFile(localPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := objectFile.ReadFrom(body); err != nil {
+		objectFile.Close()
+		return err
+	}
+	return objectFile.Close()
+}
+
+// VisitObjects calls the given function for every blob in the container whose name is provided, of which the name
+// starts with the given prefix, with the name, size and ETag of the blob, in lexicographic order. It stops at the
+// first error returned by the function.
+func (c *BlobClient) VisitObjects(ctx context.Context, containerName, prefix string, visit func(object objectstore.ObjectInfo) error) error {
+	containerURL := c.NewContainerURL(containerName)
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		resp, err := containerURL.ListBlobsFlatSegment(ctx, marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return err
+		}
+		for _, blob := range resp.Segment.BlobItems {
+			var size int64
+			if blob.Properties.ContentLength != nil {
+				size = *blob.Properties.ContentLength
+			}
+			if err := visit(objectstore.ObjectInfo{Key: blob.Name, Size: size, ETag: string(blob.Properties.Etag)}); err != nil {
+				return err
+			}
+		}
+		marker = resp.NextMarker
+	}
+	return nil
+}
+
+// ObjectIsNotFound checks if the error provided is returned for a blob that does not exist.
+func (c *BlobClient) ObjectIsNotFound(err error) bool {
+	return errors.Is(err, ErrorObjectDoesNotExist)
+}
+
+// IsPermanentError checks if the error provided is returned for a blob that does not exist, or is a storage error
+// with a permanent status code, like for invalid credentials or denied access.
+func (c *BlobClient) IsPermanentError(err error) bool {
+	var serr azblob.StorageError
+	return c.ObjectIsNotFound(err) ||
+		errors.As(err, &serr) && serr.Response() != nil && objectstore.IsPermanentStatus(serr.Response().StatusCode)
+}
+
+// Close stops the refresh of the service principal token, if the client authenticates with a service principal.
+func (c *BlobClient) Close(context.Context) {
+	if c.stopRefresh != nil {
+		c.stopRefresh()
+	}
+}
+
+// hasField returns true if the given secret data has a non-empty value for the given field.
+func hasField(secret map[string][]byte, field string) bool {
+	return len(secret[field]) > 0
+}
+
+// hasServiceCode returns true if the given error is an azblob.StorageError with the given service code.
+func hasServiceCode(err error, code azblob.ServiceCodeType) bool {
+	var serr azblob.StorageError
+	return errors.As(err, &serr) && serr.ServiceCode() == code
+}
+
+// accountNameFromURL returns the account name of the given service URL, which is the first label of the host for
+// <account>.blob.core.windows.net, or the first path element for emulators like Azurite.
+func accountNameFromURL(u *url.URL) string {
+	if p := strings.Trim(u.Path, "/"); p != "" {
+		return strings.SplitN(p, "/", 2)[0]
+	}
+	if host := u.Hostname(); strings.Contains(host, ".blob.") {
+		return strings.SplitN(host, ".", 2)[0]
+	}
+	return ""
+}
+
+// tokenResponse is the response of the Azure Active Directory token endpoint.
+type tokenResponse struct {
+	AccessToken string      `json:"access_token"`
+	ExpiresIn   json.Number `json:"expires_in"`
+}
+
+// newServicePrincipalCredential returns an azblob.TokenCredential with an access token for the service principal in
+// the given secret data, which is refreshed before it expires until the returned function is called.
+func newServicePrincipalCredential(ctx context.Context, hc *http.Client,
+	secret map[string][]byte) (azblob.TokenCredential, context.CancelFunc, error) {
+	token, expiresIn, err := servicePrincipalToken(ctx, hc, secret)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get service principal token: %w", err)
+	}
+	// The refresh outlives the given context, which is only used for the initial token.
+	refreshCtx, stop := context.WithCancel(context.Background())
+	refreshIn := refreshInterval(expiresIn)
+	return azblob.NewTokenCredential(token, func(credential azblob.TokenCredential) time.Duration {
+		// The refresher is called once on creation, while the initial token is still valid.
+		if refreshIn > 0 {
+			d := refreshIn
+			refreshIn = 0
+			return d
+		}
+		// A zero duration stops the refresh once stopped.
+		if refreshCtx.Err() != nil {
+			return 0
+		}
+		token, expiresIn, err := servicePrincipalToken(refreshCtx, hc, secret)
+		if err != nil {
+			if refreshCtx.Err() != nil {
+				return 0
+			}
+			return tokenRetryInterval
+		}
+		credential.SetToken(token)
+		return refreshInterval(expiresIn)
+	}), stop, nil
+}
+
+// servicePrincipalToken requests an access token for Azure Storage with the client credentials of the service
+// principal in the given secret data, and returns it with its lifetime.
+func servicePrincipalToken(ctx context.Context, hc *http.Client, secret map[string][]byte) (string, time.Duration, error) {
+	authorityHost := DefaultAuthorityHost
+	if hasField(secret, AuthorityHostField) {
+		authorityHost = string(secret[AuthorityHostField])
+	}
+	endpoint := strings.TrimSuffix(authorityHost, "/") + "/" + url.PathEscape(string(secret[TenantIDField])) +
+		"/oauth2/v2.0/token"
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {string(secret[ClientIDField])},
+		"client_secret": {string(secret[ClientSecretField])},
+		"scope":         {storageScope},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := hc.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned status %d", resp.StatusCode)
+	}
+	var t tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&t); err != nil {
+		return "", 0, fmt.Errorf("failed to decode token response: %w", err)
+	}
+	if t.AccessToken == "" {
+		return "", 0, errors.New("token response does not contain an access token")
+	}
+	expiresIn, err := t.ExpiresIn.Int64()
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid token lifetime '%s': %w", t.ExpiresIn, err)
+	}
+	return t.AccessToken, time.Duration(expiresIn) * time.Second, nil
+}
+
+// refreshInterval returns the interval after which a token with the given lifetime is refreshed.
+func refreshInterval(expiresIn time.Duration) time.Duration {
+	if d := expiresIn - tokenRefreshMargin; d > tokenRetryInterval {
+		return d
+	}
+	return tokenRetryInterval
+}
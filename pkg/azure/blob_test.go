@@ -0,0 +1,282 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package azure
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"gotest.tools/assert"
+
+	"github.com/fluxcd/source-controller/pkg/objectstore"
+)
+
+const (
+	// azuriteAccountName and azuriteAccountKey are the well-known credentials of the Azurite emulator.
+	azuriteAccountName = "devstoreaccount1"
+	azuriteAccountKey  = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+)
+
+func TestValidateSecret(t *testing.T) {
+	tests := []struct {
+		name    string
+		secret  map[string][]byte
+		wantErr bool
+	}{
+		{name: "shared key", secret: map[string][]byte{AccountKeyField: []byte("key")}},
+		{name: "SAS token", secret: map[string][]byte{SASTokenField: []byte("sv=2020-08-04")}},
+		{name: "service principal", secret: map[string][]byte{
+			TenantIDField: []byte("tenant"), ClientIDField: []byte("client"), ClientSecretField: []byte("secret"),
+		}},
+		{name: "incomplete service principal", secret: map[string][]byte{
+			TenantIDField: []byte("tenant"), ClientIDField: []byte("client"),
+		}, wantErr: true},
+		{name: "empty", secret: map[string][]byte{AccountNameField: []byte("account")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateSecret(tt.secret, "azure-secret")
+			assert.Equal(t, err != nil, tt.wantErr, "ValidateSecret() error = %v", err)
+		})
+	}
+}
+
+func TestAccountNameFromURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://myaccount.blob.core.windows.net":           "myaccount",
+		"https://myaccount.blob.core.windows.net/":          "myaccount",
+		"http://127.0.0.1:10000/devstoreaccount1":           "devstoreaccount1",
+		"http://azurite.default.svc:10000/devstoreaccount1": "devstoreaccount1",
+		"https://storage.example.com":                       "",
+	} {
+		u, err := url.Parse(in)
+		assert.NilError(t, err)
+		assert.Equal(t, accountNameFromURL(u), want, in)
+	}
+}
+
+func TestServicePrincipalToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/tenant/oauth2/v2.0/token" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("scope") != storageScope ||
+			r.Form.Get("client_id") != "client" || r.Form.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"token_type":"Bearer","expires_in":3599,"access_token":"token"}`)
+	}))
+	defer server.Close()
+
+	secret := map[string][]byte{
+		TenantIDField:      []byte("tenant"),
+		ClientIDField:      []byte("client"),
+		ClientSecretField:  []byte("secret"),
+		AuthorityHostField: []byte(server.URL + "/"),
+	}
+	token, expiresIn, err := servicePrincipalToken(context.TODO(), server.Client(), secret)
+	assert.NilError(t, err)
+	assert.Equal(t, token, "token")
+	assert.Equal(t, expiresIn, 3599*time.Second)
+
+	credential, stop, err := newServicePrincipalCredential(context.TODO(), server.Client(), secret)
+	assert.NilError(t, err)
+	assert.Equal(t, credential.Token(), "token")
+	stop()
+
+	secret[ClientSecretField] = []byte("invalid")
+	_, _, err = servicePrincipalToken(context.TODO(), server.Client(), secret)
+	assert.ErrorContains(t, err, "status 401")
+}
+
+func TestBlobClient_IsPermanentError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-error-code", "AuthorizationFailure")
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(context.TODO(), server.URL+"/account", nil)
+	assert.NilError(t, err)
+	_, err = client.BucketExists(context.TODO(), "container")
+	assert.Assert(t, err != nil)
+	assert.Assert(t, client.IsPermanentError(err), err)
+
+	assert.Assert(t, client.IsPermanentError(ErrorObjectDoesNotExist))
+	assert.Assert(t, !client.IsPermanentError(fmt.Errorf("connection reset by peer")))
+}
+
+func TestBlobClient_Close(t *testing.T) {
+	retryInterval := tokenRetryInterval
+	tokenRetryInterval = 10 * time.Millisecond
+	defer func() { tokenRetryInterval = retryInterval }()
+
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&requests, 1)
+		// The token expires immediately, so that it is refreshed every tokenRetryInterval.
+		fmt.Fprintf(w, `{"token_type":"Bearer","expires_in":0,"access_token":"token-%d"}`, n)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(context.TODO(), "https://account.blob.core.windows.net", map[string][]byte{
+		TenantIDField:      []byte("tenant"),
+		ClientIDField:      []byte("client"),
+		ClientSecretField:  []byte("secret"),
+		AuthorityHostField: []byte(server.URL),
+	})
+	assert.NilError(t, err)
+	assert.Assert(t, client.stopRefresh != nil)
+
+	for i := 0; i < 100 && atomic.LoadInt32(&requests) < 3; i++ {
+		time.Sleep(tokenRetryInterval)
+	}
+	assert.Assert(t, atomic.LoadInt32(&requests) >= 3, "token is not refreshed")
+
+	client.Close(context.TODO())
+	// Allow a refresh in flight when closing to complete.
+	time.Sleep(2 * tokenRetryInterval)
+	closed := atomic.LoadInt32(&requests)
+	time.Sleep(5 * tokenRetryInterval)
+	assert.Equal(t, atomic.LoadInt32(&requests), closed)
+}
+
+func TestRefreshInterval(t *testing.T) {
+	assert.Equal(t, refreshInterval(time.Hour), time.Hour-tokenRefreshMargin)
+	assert.Equal(t, refreshInterval(time.Minute), tokenRetryInterval)
+}
+
+// azuriteServiceURL returns the service URL of the Azurite emulator configured with the AZURITE_BLOB_ENDPOINT
+// environment variable, e.g. '127.0.0.1:10000/devstoreaccount1', and skips the test if it is not set.
+func azuriteServiceURL(t *testing.T) string {
+	t.Helper()
+	endpoint := os.Getenv("AZURITE_BLOB_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("AZURITE_BLOB_ENDPOINT is not set")
+	}
+	return "http://" + endpoint
+}
+
+// createContainer creates a container with a random name and the given blobs in the Azurite emulator, and returns
+// its name.
+func createContainer(t *testing.T, serviceURL string, blobs map[string]string) string {
+	t.Helper()
+	client, err := NewClient(context.TODO(), serviceURL, map[string][]byte{AccountKeyField: []byte(azuriteAccountKey)})
+	assert.NilError(t, err)
+
+	name := fmt.Sprintf("test-%d", time.Now().UnixNano())
+	containerURL := client.NewContainerURL(name)
+	_, err = containerURL.Create(context.TODO(), nil, azblob.PublicAccessNone)
+	assert.NilError(t, err)
+	t.Cleanup(func() {
+		containerURL.Delete(context.TODO(), azblob.ContainerAccessConditions{})
+	})
+	for blobName, content := range blobs {
+		_, err := azblob.UploadBufferToBlockBlob(context.TODO(), []byte(content),
+			containerURL.NewBlockBlobURL(blobName), azblob.UploadToBlockBlobOptions{})
+		assert.NilError(t, err)
+	}
+	return name
+}
+
+func TestBlobClient_Azurite(t *testing.T) {
+	serviceURL := azuriteServiceURL(t)
+	blobs := map[string]string{
+		".sourceignore":         "ignored/",
+		"deploy/manifest.yaml":  "kind: ConfigMap",
+		"deploy/nested/a.yaml":  "kind: Secret",
+		"ignored/manifest.yaml": "kind: Pod",
+	}
+	containerName := createContainer(t, serviceURL, blobs)
+
+	sharedKey, err := NewClient(context.TODO(), serviceURL, map[string][]byte{
+		AccountNameField: []byte(azuriteAccountName),
+		AccountKeyField:  []byte(azuriteAccountKey),
+	})
+	assert.NilError(t, err)
+	credential, err := azblob.NewSharedKeyCredential(azuriteAccountName, azuriteAccountKey)
+	assert.NilError(t, err)
+	sas, err := azblob.AccountSASSignatureValues{
+		Protocol:      azblob.SASProtocolHTTPSandHTTP,
+		ExpiryTime:    time.Now().UTC().Add(time.Hour),
+		Permissions:   azblob.AccountSASPermissions{Read: true, List: true}.String(),
+		Services:      azblob.AccountSASServices{Blob: true}.String(),
+		ResourceTypes: azblob.AccountSASResourceTypes{Container: true, Object: true}.String(),
+	}.NewSASQueryParameters(credential)
+	assert.NilError(t, err)
+	sasToken, err := NewClient(context.TODO(), serviceURL, map[string][]byte{SASTokenField: []byte(sas.Encode())})
+	assert.NilError(t, err)
+
+	for name, client := range map[string]*BlobClient{"shared key": sharedKey, "SAS token": sasToken} {
+		t.Run(name, func(t *testing.T) {
+			exists, err := client.BucketExists(context.TODO(), containerName)
+			assert.NilError(t, err)
+			assert.Assert(t, exists)
+			exists, err = client.BucketExists(context.TODO(), "does-not-exist")
+			assert.NilError(t, err)
+			assert.Assert(t, !exists)
+
+			got := map[string]int64{}
+			err = client.VisitObjects(context.TODO(), containerName, "", func(object objectstore.ObjectInfo) error {
+				assert.Assert(t, object.ETag != "", object.Key)
+				got[object.Key] = object.Size
+				return nil
+			})
+			assert.NilError(t, err)
+			assert.Equal(t, len(got), len(blobs))
+			for blobName, content := range blobs {
+				assert.Equal(t, got[blobName], int64(len(content)), blobName)
+			}
+
+			var prefixed []string
+			err = client.VisitObjects(context.TODO(), containerName, "deploy/", func(object objectstore.ObjectInfo) error {
+				prefixed = append(prefixed, object.Key)
+				return nil
+			})
+			assert.NilError(t, err)
+			assert.DeepEqual(t, prefixed, []string{"deploy/manifest.yaml", "deploy/nested/a.yaml"})
+
+			dir := t.TempDir()
+			localPath := filepath.Join(dir, "deploy", "nested", "a.yaml")
+			assert.NilError(t, client.FGetObject(context.TODO(), containerName, "deploy/nested/a.yaml", localPath))
+			b, err := os.ReadFile(localPath)
+			assert.NilError(t, err)
+			assert.Equal(t, string(b), blobs["deploy/nested/a.yaml"])
+
+			missing := filepath.Join(dir, "missing")
+			err = client.FGetObject(context.TODO(), containerName, "missing", missing)
+			assert.Equal(t, err, ErrorObjectDoesNotExist)
+			_, err = os.Stat(missing)
+			assert.Assert(t, os.IsNotExist(err))
+		})
+	}
+}
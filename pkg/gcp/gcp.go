@@ -21,13 +21,22 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"time"
 
 	gcpstorage "cloud.google.com/go/storage"
 	"github.com/go-logr/logr"
+	"google.golang.org/api/googleapi"
 	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
+	htransport "google.golang.org/api/transport/http"
+
+	"github.com/fluxcd/source-controller/pkg/objectstore"
 )
 
 var (
@@ -59,6 +68,46 @@ func NewClient(ctx context.Context, opts ...option.ClientOption) (*GCPClient, er
 	return &GCPClient{Client: client}, nil
 }
 
+// NewClientForEndpoint creates a new GCP storage client for the storage service at the given endpoint URL,
+// e.g. 'https://storage.googleapis.com'. The service is connected to over plain HTTP if the scheme of the URL
+// is 'http'.
+func NewClientForEndpoint(ctx context.Context, endpoint string, opts ...option.ClientOption) (*GCPClient, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("invalid endpoint '%s': %w", endpoint, err)
+	}
+	host := u.Host
+	u.Path = path.Join("/", u.Path, "storage/v1") + "/"
+	opts = append(opts, option.WithEndpoint(u.String()))
+
+	if u.Scheme == "http" {
+		// The storage client reads objects over HTTPS from the host of the endpoint, unless the
+		// STORAGE_EMULATOR_HOST environment variable is set, so those reads are downgraded by the transport.
+		transport, err := htransport.NewTransport(ctx, &insecureTransport{host: host, base: http.DefaultTransport},
+			append([]option.ClientOption{option.WithScopes(gcpstorage.ScopeFullControl)}, opts...)...)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, option.WithHTTPClient(&http.Client{Transport: transport}))
+	}
+	return NewClient(ctx, opts...)
+}
+
+// insecureTransport is an http.RoundTripper which makes the HTTPS requests to the host over plain HTTP.
+type insecureTransport struct {
+	host string
+	base http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *insecureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "https" && req.URL.Host == t.host {
+		req = req.Clone(req.Context())
+		req.URL.Scheme = "http"
+	}
+	return t.base.RoundTrip(req)
+}
+
 // ValidateSecret validates the credential secrets
 // It ensures that needed secret fields are not missing.
 func ValidateSecret(secret map[string][]byte, name string) error {
@@ -96,6 +145,21 @@ func (c *GCPClient) ObjectExists(ctx context.Context, bucketName, objectName str
 
 // FGetObject gets the object from the bucket and downloads the object locally
 func (c *GCPClient) FGetObject(ctx context.Context, bucketName, objectName, localPath string) error {
+	return c.fGetObject(ctx, c.Client.Bucket(bucketName).Object(objectName), localPath)
+}
+
+// FGetObjectVersion gets the generation of the object whose generation number is provided as version ID from the
+// bucket and downloads it locally.
+func (c *GCPClient) FGetObjectVersion(ctx context.Context, bucketName, objectName, versionID, localPath string) error {
+	generation, err := strconv.ParseInt(versionID, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid generation '%s': %w", versionID, err)
+	}
+	return c.fGetObject(ctx, c.Client.Bucket(bucketName).Object(objectName).Generation(generation), localPath)
+}
+
+// fGetObject downloads the object of the given handle locally.
+func (c *GCPClient) fGetObject(ctx context.Context, object *gcpstorage.ObjectHandle, localPath string) error {
 	// Verify if destination already exists.
 	dirStatus, err := os.Stat(localPath)
 	if err == nil {
@@ -121,15 +185,10 @@ func (c *GCPClient) FGetObject(ctx context.Context, bucketName, objectName, loca
 		}
 	}
 
-	// ObjectExists verifies if object exists and you have permission to access.
 	// Check if the object exists and if you have permission to access it.
-	exists, err := c.ObjectExists(ctx, bucketName, objectName)
-	if err != nil {
+	if _, err := object.Attrs(ctx); err != nil {
 		return err
 	}
-	if !exists {
-		return ErrorObjectDoesNotExist
-	}
 
 	objectFile, err := os.OpenFile(localPath, os.O_CREATE|os.O_WRONLY, 0600)
 	if err != nil {
@@ -137,7 +196,7 @@ func (c *GCPClient) FGetObject(ctx context.Context, bucketName, objectName, loca
 	}
 
 	// Get Object from GCP Bucket
-	objectReader, err := c.Client.Bucket(bucketName).Object(objectName).NewReader(ctx)
+	objectReader, err := object.NewReader(ctx)
 	if err != nil {
 		return err
 	}
@@ -164,9 +223,68 @@ func (c *GCPClient) ListObjects(ctx context.Context, bucketName string, query *g
 	return items
 }
 
-// Close closes the GCP Client and logs any useful errors
-func (c *GCPClient) Close(log logr.Logger) {
+// VisitObjects calls the given function for every object in the bucket whose bucket name is provided,
+// of which the name starts with the given prefix, with the name, size and ETag of the object.
+func (c *GCPClient) VisitObjects(ctx context.Context, bucketName, prefix string, visit func(object objectstore.ObjectInfo) error) error {
+	objects := c.ListObjects(ctx, bucketName, &gcpstorage.Query{Prefix: prefix})
+	for {
+		object, err := objects.Next()
+		if err == IteratorDone {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := visit(objectstore.ObjectInfo{Key: object.Name, Size: object.Size, ETag: object.Etag}); err != nil {
+			return err
+		}
+	}
+}
+
+// VisitObjectsAt calls the given function for the generation of every object in the bucket whose bucket name is
+// provided, of which the name starts with the given prefix, that was live at the given time, with the name, size, ETag
+// and generation number as version ID of the object.
+func (c *GCPClient) VisitObjectsAt(ctx context.Context, bucketName, prefix string, at time.Time,
+	visit func(object objectstore.ObjectInfo) error) error {
+	objects := c.ListObjects(ctx, bucketName, &gcpstorage.Query{Prefix: prefix, Versions: true})
+	for {
+		object, err := objects.Next()
+		if err == IteratorDone {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// A generation is live from its creation until it is deleted or replaced by a newer generation.
+		if object.Created.After(at) || (!object.Deleted.IsZero() && !object.Deleted.After(at)) {
+			continue
+		}
+		if err := visit(objectstore.ObjectInfo{
+			Key:       object.Name,
+			Size:      object.Size,
+			ETag:      object.Etag,
+			VersionID: strconv.FormatInt(object.Generation, 10),
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// ObjectIsNotFound checks if the error provided is returned for an object that does not exist.
+func (c *GCPClient) ObjectIsNotFound(err error) bool {
+	return errors.Is(err, gcpstorage.ErrObjectNotExist) || errors.Is(err, ErrorObjectDoesNotExist)
+}
+
+// IsPermanentError checks if the error provided is returned for an object that does not exist, or is an API error
+// with a permanent status code, like for invalid credentials or denied access.
+func (c *GCPClient) IsPermanentError(err error) bool {
+	var apiErr *googleapi.Error
+	return c.ObjectIsNotFound(err) || errors.As(err, &apiErr) && objectstore.IsPermanentStatus(apiErr.Code)
+}
+
+// Close closes the GCP Client and logs any useful errors to the logger in the given context.
+func (c *GCPClient) Close(ctx context.Context) {
 	if err := c.Client.Close(); err != nil {
-		log.Error(err, "GCP Provider")
+		logr.FromContextOrDiscard(ctx).Error(err, "GCP Provider")
 	}
 }
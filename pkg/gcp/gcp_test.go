@@ -33,6 +33,7 @@ import (
 
 	gcpstorage "cloud.google.com/go/storage"
 	"github.com/fluxcd/source-controller/pkg/gcp"
+	"github.com/fluxcd/source-controller/pkg/objectstore"
 	"google.golang.org/api/googleapi"
 	raw "google.golang.org/api/storage/v1"
 	"gotest.tools/assert"
@@ -88,6 +89,34 @@ func TestMain(m *testing.M) {
 			if err != nil {
 				log.Fatalf("error writing jsonResponse %v\n", err)
 			}
+		} else if r.RequestURI == fmt.Sprintf("/storage/v1/b/%s/o?alt=json&delimiter=&endOffset=&pageToken=&prefix=&prettyPrint=false&projection=full&startOffset=&versions=true", bucketName) {
+			w.WriteHeader(200)
+			response := getObjectVersions()
+			jsonResponse, err := json.Marshal(response)
+			if err != nil {
+				log.Fatalf("error marshalling response %v\n", err)
+			}
+			_, err = w.Write(jsonResponse)
+			if err != nil {
+				log.Fatalf("error writing jsonResponse %v\n", err)
+			}
+		} else if r.RequestURI == fmt.Sprintf("/storage/v1/b/%s/o/%s?alt=json&generation=1&prettyPrint=false&projection=full", bucketName, objectName) {
+			w.WriteHeader(200)
+			response := getObjectVersions().Items[0]
+			jsonResponse, err := json.Marshal(response)
+			if err != nil {
+				log.Fatalf("error marshalling response %v\n", err)
+			}
+			_, err = w.Write(jsonResponse)
+			if err != nil {
+				log.Fatalf("error writing jsonResponse %v\n", err)
+			}
+		} else if r.RequestURI == fmt.Sprintf("/%s/test.yaml?generation=1", bucketName) {
+			w.WriteHeader(200)
+			_, err = w.Write([]byte("generation: 1"))
+			if err != nil {
+				log.Fatalf("error writing response %v\n", err)
+			}
 		} else if r.RequestURI == fmt.Sprintf("/%s/test.yaml", bucketName) || r.RequestURI == fmt.Sprintf("/storage/v1/b/%s/o/%s?alt=json&prettyPrint=false&projection=full", bucketName, objectName) {
 			w.WriteHeader(200)
 			response := getObjectFile()
@@ -115,6 +144,35 @@ func TestNewClient(t *testing.T) {
 	assert.Assert(t, gcpClient != nil)
 }
 
+func TestNewClientForEndpoint(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case fmt.Sprintf("/storage/v1/b/%s", bucketName):
+			json.NewEncoder(w).Encode(getBucket())
+		case fmt.Sprintf("/storage/v1/b/%s/o/%s", bucketName, objectName):
+			json.NewEncoder(w).Encode(getObject())
+		case fmt.Sprintf("/%s/%s", bucketName, objectName):
+			w.Write([]byte(getObjectFile()))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	gcpClient, err := gcp.NewClientForEndpoint(context.Background(), ts.URL, option.WithoutAuthentication())
+	assert.NilError(t, err)
+	exists, err := gcpClient.BucketExists(context.Background(), bucketName)
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+
+	localPath := filepath.Join(t.TempDir(), objectName)
+	err = gcpClient.FGetObject(context.Background(), bucketName, objectName, localPath)
+	assert.NilError(t, err)
+	content, err := os.ReadFile(localPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(content), getObjectFile())
+}
+
 func TestBucketExists(t *testing.T) {
 	gcpClient := &gcp.GCPClient{
 		Client: client,
@@ -185,6 +243,72 @@ func TestFGetObject(t *testing.T) {
 	}
 }
 
+func TestVisitObjectsAt(t *testing.T) {
+	gcpClient := &gcp.GCPClient{
+		Client: client,
+	}
+	tests := []struct {
+		name string
+		at   time.Time
+		want map[string]string
+	}{
+		{
+			name: "before any generation",
+			at:   versionsTime.Add(-4 * time.Hour),
+			want: map[string]string{},
+		},
+		{
+			name: "with deleted object",
+			at:   versionsTime.Add(-150 * time.Minute),
+			want: map[string]string{objectName: "1", "deleted.yaml": "3"},
+		},
+		{
+			name: "with replaced generation",
+			at:   versionsTime.Add(-90 * time.Minute),
+			want: map[string]string{objectName: "1"},
+		},
+		{
+			name: "at creation of generation",
+			at:   versionsTime.Add(-time.Hour),
+			want: map[string]string{objectName: "2"},
+		},
+		{
+			name: "latest",
+			at:   versionsTime,
+			want: map[string]string{objectName: "2", "new.yaml": "4"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := map[string]string{}
+			err := gcpClient.VisitObjectsAt(context.Background(), bucketName, "", tt.at, func(object objectstore.ObjectInfo) error {
+				got[object.Key] = object.VersionID
+				return nil
+			})
+			assert.NilError(t, err)
+			assert.DeepEqual(t, got, tt.want)
+		})
+	}
+}
+
+func TestFGetObjectVersion(t *testing.T) {
+	gcpClient := &gcp.GCPClient{
+		Client: client,
+	}
+	localPath := filepath.Join(t.TempDir(), objectName)
+	err := gcpClient.FGetObjectVersion(context.Background(), bucketName, objectName, "1", localPath)
+	assert.NilError(t, err)
+	b, err := os.ReadFile(localPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "generation: 1")
+
+	err = gcpClient.FGetObjectVersion(context.Background(), bucketName, objectName, "2", localPath)
+	assert.Assert(t, gcpClient.ObjectIsNotFound(err), err)
+
+	err = gcpClient.FGetObjectVersion(context.Background(), bucketName, objectName, "latest", localPath)
+	assert.ErrorContains(t, err, "invalid generation")
+}
+
 func TestFGetObjectNotExists(t *testing.T) {
 	object := "notexists.txt"
 	tempDir, err := os.MkdirTemp("", bucketName)
@@ -284,6 +408,23 @@ func getObject() *raw.Object {
 	}
 }
 
+// versionsTime is the time the latest generation of the objects returned by getObjectVersions was created.
+var versionsTime = time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+
+func getObjectVersions() *raw.Objects {
+	at := func(d time.Duration) string {
+		return versionsTime.Add(d).Format(time.RFC3339)
+	}
+	return &raw.Objects{
+		Items: []*raw.Object{
+			{Bucket: bucketName, Name: objectName, Generation: 1, Etag: "CAE=", Size: 13, TimeCreated: at(-3 * time.Hour), TimeDeleted: at(-time.Hour)},
+			{Bucket: bucketName, Name: objectName, Generation: 2, Etag: "CAI=", Size: 13, TimeCreated: at(-time.Hour)},
+			{Bucket: bucketName, Name: "deleted.yaml", Generation: 3, Etag: "CAM=", Size: 1, TimeCreated: at(-3 * time.Hour), TimeDeleted: at(-2 * time.Hour)},
+			{Bucket: bucketName, Name: "new.yaml", Generation: 4, Etag: "CAQ=", Size: 1, TimeCreated: at(0)},
+		},
+	}
+}
+
 func getBucket() *raw.Bucket {
 	labels := map[string]string{"a": "b"}
 	matchClasses := []string{"STANDARD"}
@@ -363,3 +504,26 @@ func getObjectFile() string {
 	  timeout: 30s
 	`
 }
+
+func TestIsPermanentError(t *testing.T) {
+	gcpClient := &gcp.GCPClient{
+		Client: client,
+	}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "missing object", err: gcpstorage.ErrObjectNotExist, want: true},
+		{name: "unauthorized", err: &googleapi.Error{Code: http.StatusUnauthorized}, want: true},
+		{name: "forbidden", err: fmt.Errorf("wrapped: %w", &googleapi.Error{Code: http.StatusForbidden}), want: true},
+		{name: "too many requests", err: &googleapi.Error{Code: http.StatusTooManyRequests}},
+		{name: "server error", err: &googleapi.Error{Code: http.StatusServiceUnavailable}},
+		{name: "connection error", err: io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, gcpClient.IsPermanentError(tt.err), tt.want)
+		})
+	}
+}
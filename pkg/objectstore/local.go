@@ -0,0 +1,142 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	securejoin "github.com/cyphar/filepath-securejoin"
+)
+
+// LocalProvider is a BucketProvider for buckets on the local filesystem, where
+// every directory in the root directory is a bucket, and every regular file in
+// a bucket directory is an object. It is intended for tests and development.
+type LocalProvider struct {
+	// Root is the directory holding the bucket directories.
+	Root string
+}
+
+// NewLocalProvider returns a LocalProvider for the given root directory.
+func NewLocalProvider(root string) *LocalProvider {
+	return &LocalProvider{Root: root}
+}
+
+// BucketExists returns true if a directory with the given bucket name exists in the root directory.
+func (p *LocalProvider) BucketExists(_ context.Context, bucketName string) (bool, error) {
+	dir, err := p.bucketDir(bucketName)
+	if err != nil {
+		return false, err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return fi.IsDir(), nil
+}
+
+// FGetObject copies the file with the given key in the bucket directory to the given local path.
+func (p *LocalProvider) FGetObject(_ context.Context, bucketName, objectKey, localPath string) error {
+	dir, err := p.bucketDir(bucketName)
+	if err != nil {
+		return err
+	}
+	objectPath, err := securejoin.SecureJoin(dir, objectKey)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(objectPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	if fi, err := src.Stat(); err != nil {
+		return err
+	} else if !fi.Mode().IsRegular() {
+		return &fs.PathError{Op: "open", Path: objectPath, Err: fs.ErrNotExist}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(localPath), 0700); err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(localPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// VisitObjects calls the given function for every regular file in the bucket directory of which the key starts with
+// the given prefix, in lexical order. The ETag of a file is derived from its modification time and size.
+func (p *LocalProvider) VisitObjects(_ context.Context, bucketName, prefix string, visit func(object ObjectInfo) error) error {
+	dir, err := p.bucketDir(bucketName)
+	if err != nil {
+		return err
+	}
+	return filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() {
+			return nil
+		}
+		key, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key = filepath.ToSlash(key)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		return visit(ObjectInfo{
+			Key:  key,
+			Size: fi.Size(),
+			ETag: fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size()),
+		})
+	})
+}
+
+// ObjectIsNotFound returns true if the given error is a not exist error.
+func (p *LocalProvider) ObjectIsNotFound(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
+}
+
+// IsPermanentError returns true if the given error is a not exist or permission error.
+func (p *LocalProvider) IsPermanentError(err error) bool {
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission)
+}
+
+// Close does nothing, as the LocalProvider holds no resources.
+func (p *LocalProvider) Close(context.Context) {}
+
+// bucketDir returns the directory of the bucket with the given name in the root directory.
+func (p *LocalProvider) bucketDir(bucketName string) (string, error) {
+	return securejoin.SecureJoin(p.Root, bucketName)
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+
+	"github.com/fluxcd/source-controller/pkg/azure"
+	"github.com/fluxcd/source-controller/pkg/gcp"
+	"github.com/fluxcd/source-controller/pkg/objectstore"
+)
+
+var (
+	_ objectstore.BucketProvider = &objectstore.LocalProvider{}
+	_ objectstore.BucketProvider = &objectstore.MinioProvider{}
+	_ objectstore.BucketProvider = &gcp.GCPClient{}
+	_ objectstore.BucketProvider = &azure.BlobClient{}
+
+	_ objectstore.VersionedBucketProvider = &objectstore.MinioProvider{}
+	_ objectstore.VersionedBucketProvider = &gcp.GCPClient{}
+)
+
+func TestLocalProvider(t *testing.T) {
+	root := t.TempDir()
+	assert.NilError(t, os.MkdirAll(filepath.Join(root, "bucket", "dir", "empty"), 0755))
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "bucket", "a.yaml"), []byte("a"), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "bucket", "dir", "b.yaml"), []byte("bb"), 0644))
+	assert.NilError(t, os.WriteFile(filepath.Join(root, "file"), []byte("not a bucket"), 0644))
+	provider := objectstore.NewLocalProvider(root)
+	ctx := context.TODO()
+
+	exists, err := provider.BucketExists(ctx, "bucket")
+	assert.NilError(t, err)
+	assert.Assert(t, exists)
+	for _, name := range []string{"does-not-exist", "file"} {
+		exists, err = provider.BucketExists(ctx, name)
+		assert.NilError(t, err)
+		assert.Assert(t, !exists, name)
+	}
+
+	got := map[string]int64{}
+	assert.NilError(t, provider.VisitObjects(ctx, "bucket", "", func(object objectstore.ObjectInfo) error {
+		assert.Assert(t, object.ETag != "", object.Key)
+		got[object.Key] = object.Size
+		return nil
+	}))
+	assert.DeepEqual(t, got, map[string]int64{"a.yaml": 1, "dir/b.yaml": 2})
+
+	got = map[string]int64{}
+	assert.NilError(t, provider.VisitObjects(ctx, "bucket", "dir/", func(object objectstore.ObjectInfo) error {
+		got[object.Key] = object.Size
+		return nil
+	}))
+	assert.DeepEqual(t, got, map[string]int64{"dir/b.yaml": 2})
+
+	localPath := filepath.Join(t.TempDir(), "dir", "b.yaml")
+	assert.NilError(t, provider.FGetObject(ctx, "bucket", "dir/b.yaml", localPath))
+	b, err := os.ReadFile(localPath)
+	assert.NilError(t, err)
+	assert.Equal(t, string(b), "bb")
+
+	for _, key := range []string{"missing.yaml", "dir", "../file"} {
+		err = provider.FGetObject(ctx, "bucket", key, filepath.Join(t.TempDir(), "object"))
+		assert.Assert(t, provider.ObjectIsNotFound(err), "%s: %v", key, err)
+	}
+}
@@ -0,0 +1,189 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/s3utils"
+)
+
+const (
+	// CAFileField is the field of a Bucket secret holding the PEM encoded CA
+	// certificate the endpoint is verified with, in addition to the system
+	// certificate pool.
+	CAFileField = "caFile"
+	// CertFileField is the field of a Bucket secret holding the PEM encoded
+	// client certificate to authenticate to the endpoint with.
+	CertFileField = "certFile"
+	// KeyFileField is the field of a Bucket secret holding the PEM encoded
+	// private key of the client certificate.
+	KeyFileField = "keyFile"
+)
+
+// MinioProvider is a BucketProvider for S3 compatible storage services.
+type MinioProvider struct {
+	// Client is the Minio client for the storage service.
+	*minio.Client
+}
+
+// NewMinioProvider returns a MinioProvider for the given Minio client.
+func NewMinioProvider(client *minio.Client) *MinioProvider {
+	return &MinioProvider{Client: client}
+}
+
+// FGetObject downloads the object with the given key from the bucket to the given local path.
+func (p *MinioProvider) FGetObject(ctx context.Context, bucketName, objectKey, localPath string) error {
+	return p.Client.FGetObject(ctx, bucketName, objectKey, localPath, minio.GetObjectOptions{})
+}
+
+// VisitObjects calls the given function for every object in the bucket with the given key prefix.
+func (p *MinioProvider) VisitObjects(ctx context.Context, bucketName, prefix string, visit func(object ObjectInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	// Stop the listing when returning early.
+	defer cancel()
+	for object := range p.Client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+		UseV1:     s3utils.IsGoogleEndpoint(*p.Client.EndpointURL()),
+	}) {
+		if object.Err != nil {
+			return object.Err
+		}
+		if err := visit(ObjectInfo{Key: object.Key, Size: object.Size, ETag: object.ETag}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VisitObjectsAt calls the given function for the version of every object in the bucket with the given key prefix,
+// that was the latest version at the given time and is not a delete marker, in lexical order of the keys.
+func (p *MinioProvider) VisitObjectsAt(ctx context.Context, bucketName, prefix string, at time.Time,
+	visit func(object ObjectInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	// Stop the listing when returning early.
+	defer cancel()
+	var versions []minio.ObjectInfo
+	for version := range p.Client.ListObjects(ctx, bucketName, minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    true,
+		WithVersions: true,
+	}) {
+		if version.Err != nil {
+			return version.Err
+		}
+		versions = append(versions, version)
+	}
+	for _, object := range latestVersionsAt(versions, at) {
+		if err := visit(object); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FGetObjectVersion downloads the version with the given ID of the object with the given key from the bucket to the
+// given local path.
+func (p *MinioProvider) FGetObjectVersion(ctx context.Context, bucketName, objectKey, versionID, localPath string) error {
+	return p.Client.FGetObject(ctx, bucketName, objectKey, localPath, minio.GetObjectOptions{VersionID: versionID})
+}
+
+// latestVersionsAt returns the ObjectInfo of the latest version of every object at the given time, sorted by key.
+// Objects of which the latest version is a delete marker, or that have no version at the given time, are left out.
+func latestVersionsAt(versions []minio.ObjectInfo, at time.Time) []ObjectInfo {
+	latest := make(map[string]minio.ObjectInfo)
+	for _, version := range versions {
+		if version.LastModified.After(at) {
+			continue
+		}
+		if l, ok := latest[version.Key]; !ok || version.LastModified.After(l.LastModified) {
+			latest[version.Key] = version
+		}
+	}
+	objects := make([]ObjectInfo, 0, len(latest))
+	for _, version := range latest {
+		if version.IsDeleteMarker {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Key:       version.Key,
+			Size:      version.Size,
+			ETag:      version.ETag,
+			VersionID: version.VersionID,
+		})
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects
+}
+
+// ObjectIsNotFound returns true if the given error is a NoSuchKey error response.
+func (p *MinioProvider) ObjectIsNotFound(err error) bool {
+	return minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+// IsPermanentError returns true if the given error is an error response with a permanent status code, like for a
+// missing object, invalid credentials or denied access.
+func (p *MinioProvider) IsPermanentError(err error) bool {
+	return IsPermanentStatus(minio.ToErrorResponse(err).StatusCode)
+}
+
+// Close does nothing, as the Minio client holds no resources.
+func (p *MinioProvider) Close(context.Context) {}
+
+// TLSClientConfig returns the tls.Config for the CA certificate and client certificate in the given secret data, or
+// nil if the secret data contains none of the CAFileField, CertFileField and KeyFileField fields.
+func TLSClientConfig(secret map[string][]byte) (*tls.Config, error) {
+	certBytes, keyBytes, caBytes := secret[CertFileField], secret[KeyFileField], secret[CAFileField]
+	switch {
+	case len(certBytes)+len(keyBytes)+len(caBytes) == 0:
+		return nil, nil
+	case (len(certBytes) > 0) != (len(keyBytes) > 0):
+		return nil, fmt.Errorf("fields '%s' and '%s' require each other's presence", CertFileField, KeyFileField)
+	}
+
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if len(certBytes) > 0 {
+		cert, err := tls.X509KeyPair(certBytes, keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	if len(caBytes) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, errors.New("invalid CA certificate: no PEM encoded certificate found")
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
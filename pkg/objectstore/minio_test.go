@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"gotest.tools/assert"
+)
+
+func Test_latestVersionsAt(t *testing.T) {
+	base := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	versions := []minio.ObjectInfo{
+		{Key: "b.yaml", VersionID: "b2", ETag: "b2", LastModified: base.Add(2 * time.Hour), IsLatest: true},
+		{Key: "b.yaml", VersionID: "b1", ETag: "b1", LastModified: base},
+		{Key: "a.yaml", VersionID: "a3", LastModified: base.Add(3 * time.Hour), IsDeleteMarker: true, IsLatest: true},
+		{Key: "a.yaml", VersionID: "a2", ETag: "a2", LastModified: base.Add(time.Hour)},
+		{Key: "a.yaml", VersionID: "null", ETag: "a1", LastModified: base.Add(-time.Hour)},
+		{Key: "c.yaml", VersionID: "c1", ETag: "c1", LastModified: base.Add(4 * time.Hour), IsLatest: true},
+	}
+	versionIDs := func(objects []ObjectInfo) []string {
+		ids := []string{}
+		for _, object := range objects {
+			ids = append(ids, object.Key+"@"+object.VersionID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want []string
+	}{
+		{name: "before any version", at: base.Add(-2 * time.Hour), want: []string{}},
+		{name: "version without ID", at: base.Add(-time.Hour), want: []string{"a.yaml@null"}},
+		{name: "at last modified", at: base, want: []string{"a.yaml@null", "b.yaml@b1"}},
+		{name: "between versions", at: base.Add(90 * time.Minute), want: []string{"a.yaml@a2", "b.yaml@b1"}},
+		{name: "after delete marker", at: base.Add(3 * time.Hour), want: []string{"b.yaml@b2"}},
+		{name: "latest", at: base.Add(5 * time.Hour), want: []string{"b.yaml@b2", "c.yaml@c1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.DeepEqual(t, versionIDs(latestVersionsAt(versions, tt.at)), tt.want)
+		})
+	}
+}
+
+func TestTLSClientConfig(t *testing.T) {
+	config, err := TLSClientConfig(map[string][]byte{"accesskey": []byte("key")})
+	assert.NilError(t, err)
+	assert.Assert(t, config == nil)
+
+	_, err = TLSClientConfig(map[string][]byte{CAFileField: []byte("not a certificate")})
+	assert.ErrorContains(t, err, "invalid CA certificate")
+
+	_, err = TLSClientConfig(map[string][]byte{KeyFileField: []byte("key")})
+	assert.ErrorContains(t, err, "require each other's presence")
+
+	_, err = TLSClientConfig(map[string][]byte{CertFileField: []byte("cert"), KeyFileField: []byte("key")})
+	assert.ErrorContains(t, err, "invalid client certificate")
+}
+
+func TestMinioProvider_IsPermanentError(t *testing.T) {
+	provider := &MinioProvider{}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "missing object", err: minio.ErrorResponse{Code: "NoSuchKey", StatusCode: http.StatusNotFound}, want: true},
+		{name: "access denied", err: minio.ErrorResponse{Code: "AccessDenied", StatusCode: http.StatusForbidden}, want: true},
+		{name: "invalid access key", err: minio.ErrorResponse{Code: "InvalidAccessKeyId", StatusCode: http.StatusForbidden}, want: true},
+		{name: "throttled", err: minio.ErrorResponse{Code: "SlowDown", StatusCode: http.StatusServiceUnavailable}},
+		{name: "too many requests", err: minio.ErrorResponse{StatusCode: http.StatusTooManyRequests}},
+		{name: "request timeout", err: minio.ErrorResponse{Code: "RequestTimeout", StatusCode: http.StatusRequestTimeout}},
+		{name: "connection error", err: errors.New("connection reset by peer")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, provider.IsPermanentError(tt.err), tt.want)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package objectstore defines the BucketProvider interface the Bucket
+// reconciler fetches objects with, and provides implementations for S3
+// compatible storage and the local filesystem.
+package objectstore
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// BucketProvider is an object storage service from which the objects in a
+// bucket can be listed and downloaded.
+type BucketProvider interface {
+	// BucketExists returns true if the bucket with the given name exists.
+	BucketExists(ctx context.Context, bucketName string) (bool, error)
+
+	// FGetObject downloads the object with the given key from the bucket to
+	// the given local path, creating any missing parent directories. The
+	// error returned for an object that does not exist satisfies
+	// ObjectIsNotFound.
+	FGetObject(ctx context.Context, bucketName, objectKey, localPath string) error
+
+	// VisitObjects calls the given function with the ObjectInfo of every
+	// object in the bucket of which the key starts with the given prefix.
+	// It stops at, and returns, the first error returned by the function.
+	VisitObjects(ctx context.Context, bucketName, prefix string, visit func(object ObjectInfo) error) error
+
+	// ObjectIsNotFound returns true if the given error was returned for an
+	// object that does not exist.
+	ObjectIsNotFound(err error) bool
+
+	// IsPermanentError returns true if the given error is returned again
+	// when the request is retried, like for an object that does not exist,
+	// invalid credentials or denied access.
+	IsPermanentError(err error) bool
+
+	// Close releases the resources held by the provider, and logs any
+	// errors to the logger in the given context.
+	Close(ctx context.Context)
+}
+
+// VersionedBucketProvider is a BucketProvider for buckets with object
+// versioning, from which the objects can be listed and downloaded as they
+// were at a point in time.
+type VersionedBucketProvider interface {
+	BucketProvider
+
+	// VisitObjectsAt calls the given function with the ObjectInfo of the
+	// version of every object in the bucket of which the key starts with the
+	// given prefix, that was the latest version at the given time. Objects
+	// that did not exist at the given time are not visited. It stops at, and
+	// returns, the first error returned by the function.
+	VisitObjectsAt(ctx context.Context, bucketName, prefix string, at time.Time, visit func(object ObjectInfo) error) error
+
+	// FGetObjectVersion downloads the version with the given ID of the object
+	// with the given key from the bucket to the given local path, like
+	// FGetObject.
+	FGetObjectVersion(ctx context.Context, bucketName, objectKey, versionID, localPath string) error
+}
+
+// ObjectInfo describes an object in a bucket.
+type ObjectInfo struct {
+	// Key is the forward slash separated key of the object.
+	Key string
+
+	// Size is the size of the object in bytes.
+	Size int64
+
+	// ETag is an opaque identifier of the content of the object, which
+	// changes when the object is written. It is empty if the provider does
+	// not return one.
+	ETag string
+
+	// VersionID is the ID of the version of the object. It is only set by
+	// VersionedBucketProvider.VisitObjectsAt.
+	VersionID string
+}
+
+// IsPermanentStatus returns true if the given HTTP status code is a client
+// error that is returned again when the request is retried, which are all 4xx
+// status codes except 408 Request Timeout and 429 Too Many Requests.
+func IsPermanentStatus(code int) bool {
+	return code >= 400 && code < 500 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// NewSTSAssumeRole returns the credentials.Credentials of the role assumed
+// with the given options at the STS endpoint, which are requested with the
+// given HTTP client.
+func NewSTSAssumeRole(client *http.Client, endpoint string, opts credentials.STSAssumeRoleOptions) (*credentials.Credentials, error) {
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, errors.New("AssumeRole requires an access key and secret key")
+	}
+	return credentials.New(&credentials.STSAssumeRole{
+		Client:      client,
+		STSEndpoint: endpoint,
+		Options:     opts,
+	}), nil
+}
+
+// NewSTSWebIdentity returns the credentials.Credentials of the role assumed
+// with the web identity token in the given file at the STS endpoint, which
+// are requested with the given HTTP client.
+func NewSTSWebIdentity(client *http.Client, endpoint, tokenFile, roleARN, roleSessionName string) *credentials.Credentials {
+	return credentials.New(&STSWebIdentity{
+		Client:          client,
+		STSEndpoint:     endpoint,
+		TokenFile:       tokenFile,
+		RoleARN:         roleARN,
+		RoleSessionName: roleSessionName,
+	})
+}
+
+// STSWebIdentity is a credentials.Provider which retrieves temporary
+// credentials with AssumeRoleWithWebIdentity, using a token that is read
+// from a file on every retrieval, so that a projected service account token
+// can be used.
+type STSWebIdentity struct {
+	credentials.Expiry
+
+	// Client is the HTTP client the credentials are requested with.
+	Client *http.Client
+
+	// STSEndpoint is the URL of the Security Token Service.
+	STSEndpoint string
+
+	// TokenFile is the path to the file holding the web identity token.
+	TokenFile string
+
+	// RoleARN is the Amazon Resource Name of the role to assume. It is
+	// required by AWS, but optional for MinIO.
+	RoleARN string
+
+	// RoleSessionName is the identifier of the assumed role session.
+	RoleSessionName string
+}
+
+// Retrieve requests new credentials from the STS.
+func (p *STSWebIdentity) Retrieve() (credentials.Value, error) {
+	token, err := os.ReadFile(p.TokenFile)
+	if err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to read web identity token: %w", err)
+	}
+
+	u, err := url.Parse(p.STSEndpoint)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	v := url.Values{}
+	v.Set("Action", "AssumeRoleWithWebIdentity")
+	v.Set("Version", credentials.STSVersion)
+	v.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+	if p.RoleARN != "" {
+		v.Set("RoleArn", p.RoleARN)
+	}
+	if p.RoleSessionName != "" {
+		v.Set("RoleSessionName", p.RoleSessionName)
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), strings.NewReader(v.Encode()))
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return credentials.Value{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return credentials.Value{}, fmt.Errorf("AssumeRoleWithWebIdentity failed with status %s: %s",
+			resp.Status, strings.TrimSpace(string(body)))
+	}
+	var result credentials.AssumeRoleWithWebIdentityResponse
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return credentials.Value{}, fmt.Errorf("failed to decode AssumeRoleWithWebIdentity response: %w", err)
+	}
+
+	creds := result.Result.Credentials
+	p.SetExpiration(creds.Expiration, credentials.DefaultExpiryWindow)
+	return credentials.Value{
+		AccessKeyID:     creds.AccessKey,
+		SecretAccessKey: creds.SecretKey,
+		SessionToken:    creds.SessionToken,
+		SignerType:      credentials.SignatureV4,
+	}, nil
+}
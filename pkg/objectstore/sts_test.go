@@ -0,0 +1,119 @@
+/*
+Copyright 2021 The Flux authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package objectstore
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"gotest.tools/assert"
+)
+
+const webIdentityResponse = `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>%s</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>session</SessionToken>
+      <Expiration>%s</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`
+
+func TestSTSWebIdentity(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	assert.NilError(t, os.WriteFile(tokenFile, []byte("first-token\n"), 0o600))
+
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if err := r.ParseForm(); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if r.Form.Get("Action") != "AssumeRoleWithWebIdentity" || r.Form.Get("RoleArn") != "arn:aws:iam::123456789012:role/source" ||
+			r.Form.Get("RoleSessionName") != "default.podinfo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch token := r.Form.Get("WebIdentityToken"); token {
+		case "first-token", "second-token":
+			// The access key reveals which token the credentials were issued for.
+			fmt.Fprintf(w, webIdentityResponse, token, time.Now().Add(-time.Minute).UTC().Format(time.RFC3339))
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "invalid token")
+		}
+	}))
+	defer ts.Close()
+
+	creds := NewSTSWebIdentity(ts.Client(), ts.URL, tokenFile, "arn:aws:iam::123456789012:role/source", "default.podinfo")
+	value, err := creds.Get()
+	assert.NilError(t, err)
+	assert.Equal(t, value.AccessKeyID, "first-token")
+	assert.Equal(t, value.SecretAccessKey, "secret")
+	assert.Equal(t, value.SessionToken, "session")
+
+	// The credentials are expired, so the rotated token is read on the
+	// next retrieval.
+	assert.NilError(t, os.WriteFile(tokenFile, []byte("second-token"), 0o600))
+	value, err = creds.Get()
+	assert.NilError(t, err)
+	assert.Equal(t, value.AccessKeyID, "second-token")
+	assert.Equal(t, requests, 2)
+
+	assert.NilError(t, os.WriteFile(tokenFile, []byte("revoked-token"), 0o600))
+	_, err = creds.Get()
+	assert.ErrorContains(t, err, "403 Forbidden: invalid token")
+
+	_, err = NewSTSWebIdentity(ts.Client(), ts.URL, filepath.Join(t.TempDir(), "missing"), "", "").Get()
+	assert.ErrorContains(t, err, "failed to read web identity token")
+}
+
+func TestNewSTSAssumeRole(t *testing.T) {
+	_, err := NewSTSAssumeRole(http.DefaultClient, "https://sts.amazonaws.com", credentials.STSAssumeRoleOptions{AccessKey: "key"})
+	assert.ErrorContains(t, err, "requires an access key and secret key")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("Action") != "AssumeRole" ||
+			!strings.Contains(r.Header.Get("Authorization"), "Credential=key/") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult><Credentials>
+    <AccessKeyId>assumed</AccessKeyId><SecretAccessKey>secret</SecretAccessKey>
+    <SessionToken>session</SessionToken><Expiration>%s</Expiration>
+  </Credentials></AssumeRoleResult>
+</AssumeRoleResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer ts.Close()
+
+	creds, err := NewSTSAssumeRole(ts.Client(), ts.URL, credentials.STSAssumeRoleOptions{AccessKey: "key", SecretKey: "secret"})
+	assert.NilError(t, err)
+	value, err := creds.Get()
+	assert.NilError(t, err)
+	assert.Equal(t, value.AccessKeyID, "assumed")
+	assert.Equal(t, value.SessionToken, "session")
+}